@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mementor/hafenbot/metrics"
+	"github.com/mementor/hafenbot/storage"
+	"github.com/mementor/hafenbot/timer"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// apiPrefix is path prefix of all JSON API endpoints
+const apiPrefix = "/api/v1"
+
+// maxAPIRequest limits size of JSON API request body
+const maxAPIRequest = 64 << 10
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+// apiServer implements JSON API described in openapi.json.
+// Every request except the spec itself is authenticated with chat API key
+// and works only with timers and subscription of that chat
+type apiServer struct {
+	store  storage.Storage
+	reload chan bool
+}
+
+type apiTimer struct {
+	ID     string    `json:"id"`
+	ChatID int64     `json:"chat_id"`
+	At     time.Time `json:"at"`
+	Body   string    `json:"body"`
+}
+
+// apiTimerRequest is body of create and update requests.
+// Fire time is given either as At or as In duration ("1h30m", "2d")
+type apiTimerRequest struct {
+	At   *time.Time `json:"at"`
+	In   string     `json:"in"`
+	Body *string    `json:"body"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func newAPITimer(t *timer.Timer) apiTimer {
+	return apiTimer{ID: t.ID, ChatID: t.ChatID, At: t.At.In(location), Body: t.Body}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("can't write response", "err", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, apiError{Error: msg})
+}
+
+// writeStorageError reports storage failure to API client
+func writeStorageError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		writeAPIError(w, http.StatusNotFound, "not found")
+	case errors.Is(err, storage.ErrConflict):
+		writeAPIError(w, http.StatusConflict, "conflict")
+	default:
+		slog.Error("API storage call failed", "err", err)
+		writeAPIError(w, http.StatusServiceUnavailable, "storage unavailable")
+	}
+}
+
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+func (api *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, apiPrefix)
+	if path == "/openapi.json" {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write(openAPISpec)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), storageTimeout)
+	defer cancel()
+	chatID, ok := api.authenticate(ctx, w, r)
+	if !ok {
+		return
+	}
+
+	switch {
+	case path == "/timers":
+		switch r.Method {
+		case http.MethodGet:
+			api.listTimers(ctx, w, chatID)
+		case http.MethodPost:
+			api.createTimer(ctx, w, r, chatID)
+		default:
+			methodNotAllowed(w, "GET, POST")
+		}
+	case strings.HasPrefix(path, "/timers/") && len(path) > len("/timers/"):
+		id := strings.TrimPrefix(path, "/timers/")
+		switch r.Method {
+		case http.MethodGet:
+			api.getTimer(ctx, w, chatID, id)
+		case http.MethodPatch:
+			api.updateTimer(ctx, w, r, chatID, id)
+		case http.MethodDelete:
+			api.deleteTimer(ctx, w, chatID, id)
+		default:
+			methodNotAllowed(w, "GET, PATCH, DELETE")
+		}
+	case path == "/subscription":
+		switch r.Method {
+		case http.MethodGet:
+			api.getSubscription(ctx, w, chatID)
+		case http.MethodPut:
+			api.subscribe(ctx, w, chatID)
+		case http.MethodDelete:
+			api.unsubscribe(ctx, w, chatID)
+		default:
+			methodNotAllowed(w, "GET, PUT, DELETE")
+		}
+	default:
+		writeAPIError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// authenticate resolves chat by "Authorization: Bearer <key>" header
+func (api *apiServer) authenticate(ctx context.Context, w http.ResponseWriter, r *http.Request) (int64, bool) {
+	key := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if key == "" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeAPIError(w, http.StatusUnauthorized, "API key required")
+		return 0, false
+	}
+	chatID, err := api.store.GetChatByToken(ctx, tokenKindAPIKey, hashToken(key))
+	if errors.Is(err, storage.ErrNotFound) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeAPIError(w, http.StatusUnauthorized, "invalid API key")
+		return 0, false
+	}
+	if err != nil {
+		writeStorageError(w, err)
+		return 0, false
+	}
+	return chatID, true
+}
+
+// decodeTimerRequest reads request body and resolves fire time
+func decodeTimerRequest(w http.ResponseWriter, r *http.Request) (req apiTimerRequest, at time.Time, err error) {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIRequest))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(&req); err != nil {
+		return req, at, fmt.Errorf("bad JSON: %s", err)
+	}
+	if req.At != nil && req.In != "" {
+		return req, at, errors.New("only one of 'at' and 'in' can be given")
+	}
+	if req.At != nil {
+		at = *req.At
+	}
+	if req.In != "" {
+		dur, err := parseDuration(req.In)
+		if err != nil {
+			return req, at, fmt.Errorf("bad 'in': %s", err)
+		}
+		at = time.Now().Add(dur)
+	}
+	return req, at, nil
+}
+
+func (api *apiServer) listTimers(ctx context.Context, w http.ResponseWriter, chatID int64) {
+	timers, err := api.store.ListChatTimers(ctx, chatID)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	resp := make([]apiTimer, 0, len(timers))
+	for i := range timers {
+		if timers[i].Trigger != "" {
+			continue
+		}
+		resp = append(resp, newAPITimer(&timers[i]))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (api *apiServer) createTimer(ctx context.Context, w http.ResponseWriter, r *http.Request, chatID int64) {
+	req, at, err := decodeTimerRequest(w, r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var body string
+	if req.Body != nil {
+		body = strings.TrimSpace(*req.Body)
+	}
+	if err = validateTimer(at, body, time.Now()); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	t := &timer.Timer{At: at, Body: body, ChatID: chatID}
+	if err = api.store.SaveTimer(ctx, t); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	metrics.TimersCreated.WithLabelValues("api").Inc()
+	api.reload <- true
+	w.Header().Set("Location", fmt.Sprintf("%s/timers/%s", apiPrefix, t.ID))
+	writeJSON(w, http.StatusCreated, newAPITimer(t))
+}
+
+func (api *apiServer) getTimer(ctx context.Context, w http.ResponseWriter, chatID int64, id string) {
+	t, err := api.store.GetTimerByChatAndID(ctx, chatID, id)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	if t.Trigger != "" {
+		writeAPIError(w, http.StatusNotFound, "not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPITimer(t))
+}
+
+func (api *apiServer) updateTimer(ctx context.Context, w http.ResponseWriter, r *http.Request, chatID int64, id string) {
+	req, at, err := decodeTimerRequest(w, r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	t, err := api.store.GetTimerByChatAndID(ctx, chatID, id)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	// /when timers are not part of the API, list hides them too
+	if t.Trigger != "" {
+		writeAPIError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if !at.IsZero() {
+		t.At = at
+		t.Rearm(time.Now())
+	}
+	if req.Body != nil {
+		t.Body = strings.TrimSpace(*req.Body)
+	}
+	if err = validateTimer(t.At, t.Body, time.Now()); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// update fails with ErrConflict if scheduler claimed the timer meanwhile
+	if err = api.store.UpdateTimer(ctx, t); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	api.reload <- true
+	writeJSON(w, http.StatusOK, newAPITimer(t))
+}
+
+func (api *apiServer) deleteTimer(ctx context.Context, w http.ResponseWriter, chatID int64, id string) {
+	if err := api.store.DeleteTimer(ctx, chatID, id); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	metrics.TimersDeleted.WithLabelValues("api").Inc()
+	api.reload <- true
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *apiServer) getSubscription(ctx context.Context, w http.ResponseWriter, chatID int64) {
+	chats, err := api.store.GetSSChats(ctx)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	subscribed := false
+	for _, c := range chats {
+		if c == chatID {
+			subscribed = true
+			break
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"subscribed": subscribed})
+}
+
+func (api *apiServer) subscribe(ctx context.Context, w http.ResponseWriter, chatID int64) {
+	err := api.store.AppendToSSList(ctx, chatID)
+	if err != nil && !errors.Is(err, storage.ErrAlreadySubscribed) {
+		writeStorageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"subscribed": true})
+}
+
+func (api *apiServer) unsubscribe(ctx context.Context, w http.ResponseWriter, chatID int64) {
+	err := api.store.DeleteFromSSList(ctx, chatID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		writeStorageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"subscribed": false})
+}
+
+// handleAPIKey manages chat API key: /apikey on|off|rotate
+func handleAPIKey(ctx context.Context, bot *tgbotapi.BotAPI, dbstore storage.Storage, chatID int64, arg string) {
+	if publicURL == "" {
+		bot.Send(tgbotapi.NewMessage(chatID, "API is disabled on this bot"))
+		return
+	}
+	mode := strings.ToLower(strings.TrimSpace(arg))
+	if mode != "on" && mode != "off" && mode != "rotate" {
+		bot.Send(tgbotapi.NewMessage(chatID, "usage: /apikey on|off|rotate"))
+		return
+	}
+	key, err := manageChatToken(ctx, dbstore, tokenKindAPIKey, chatID, mode)
+	var reply string
+	switch {
+	case errors.Is(err, storage.ErrConflict):
+		reply = "API key is already issued\n/apikey rotate to get a new one, the old one will stop working"
+	case errors.Is(err, storage.ErrNotFound) && mode == "off":
+		reply = "API key is already revoked"
+	case errors.Is(err, storage.ErrNotFound):
+		reply = "No API key here\n/apikey on to issue one"
+	case err != nil:
+		logger(ctx).Error("can't change API key", "mode", mode, "err", err)
+		reply = "error: can't change API key, try again later"
+	case mode == "off":
+		reply = "API key is revoked"
+	default:
+		reply = fmt.Sprintf("API key of this chat:\n%s\nAnyone with the key can manage timers of this chat\nAPI description: %s%s/openapi.json\n/apikey rotate to change it, /apikey off to revoke", key, publicURL, apiPrefix)
+	}
+	bot.Send(tgbotapi.NewMessage(chatID, reply))
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mementor/hafenbot/timer"
+)
+
+// newTestAPI serves API over memStore with API key issued for chatID
+func newTestAPI(t *testing.T, chatID int64) (*httptest.Server, *memStore, string) {
+	t.Helper()
+	store := newMemStore()
+	key, err := manageChatToken(context.Background(), store, tokenKindAPIKey, chatID, "on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &apiServer{store: store, reload: make(chan bool, 100)}
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	return srv, store, key
+}
+
+func apiCall(t *testing.T, srv *httptest.Server, key, method, path, body string) (int, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+apiPrefix+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var data json.RawMessage
+	if resp.StatusCode != http.StatusNoContent {
+		if err = json.NewDecoder(resp.Body).Decode(&data); err != nil {
+			t.Fatalf("%s %s: bad response: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode, data
+}
+
+func TestAPIAuth(t *testing.T) {
+	srv, store, key := newTestAPI(t, 1)
+	if code, _ := apiCall(t, srv, "", "GET", "/timers", ""); code != http.StatusUnauthorized {
+		t.Errorf("missing key: status %d, want 401", code)
+	}
+	if code, _ := apiCall(t, srv, key+"x", "GET", "/timers", ""); code != http.StatusUnauthorized {
+		t.Errorf("wrong key: status %d, want 401", code)
+	}
+	if code, _ := apiCall(t, srv, key, "GET", "/timers", ""); code != http.StatusOK {
+		t.Errorf("valid key: status %d, want 200", code)
+	}
+	if _, err := manageChatToken(context.Background(), store, tokenKindAPIKey, 1, "off"); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := apiCall(t, srv, key, "GET", "/timers", ""); code != http.StatusUnauthorized {
+		t.Errorf("revoked key: status %d, want 401", code)
+	}
+	// the spec is public
+	resp, err := srv.Client().Get(srv.URL + apiPrefix + "/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("spec: status %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestAPITimers(t *testing.T) {
+	srv, _, key := newTestAPI(t, 1)
+
+	code, data := apiCall(t, srv, key, "POST", "/timers", `{"in": "1h", "body": "feed pigs"}`)
+	if code != http.StatusCreated {
+		t.Fatalf("create: status %d: %s", code, data)
+	}
+	var created apiTimer
+	if err := json.Unmarshal(data, &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.ID == "" || created.ChatID != 1 || created.Body != "feed pigs" {
+		t.Fatalf("create: got %+v", created)
+	}
+	if code, data = apiCall(t, srv, key, "POST", "/timers", `{"in": "1h"}`); code != http.StatusBadRequest {
+		t.Errorf("create without body: status %d: %s", code, data)
+	}
+	if code, data = apiCall(t, srv, key, "POST", "/timers", `{"in": "1h", "body": "x", "color": "red"}`); code != http.StatusBadRequest {
+		t.Errorf("create with unknown field: status %d: %s", code, data)
+	}
+
+	var list []apiTimer
+	code, data = apiCall(t, srv, key, "GET", "/timers", "")
+	if err := json.Unmarshal(data, &list); code != http.StatusOK || err != nil || len(list) != 1 || list[0].ID != created.ID {
+		t.Fatalf("list: status %d: %s", code, data)
+	}
+
+	var got apiTimer
+	code, data = apiCall(t, srv, key, "GET", "/timers/"+created.ID, "")
+	if err := json.Unmarshal(data, &got); code != http.StatusOK || err != nil || got.Body != "feed pigs" {
+		t.Fatalf("get: status %d: %s", code, data)
+	}
+
+	at := time.Now().Add(3 * time.Hour).Truncate(time.Second)
+	patch := `{"at": "` + at.Format(time.RFC3339) + `", "body": "feed cows"}`
+	code, data = apiCall(t, srv, key, "PATCH", "/timers/"+created.ID, patch)
+	if err := json.Unmarshal(data, &got); code != http.StatusOK || err != nil || got.Body != "feed cows" || !got.At.Equal(at) {
+		t.Fatalf("patch: status %d: %s", code, data)
+	}
+
+	if code, _ = apiCall(t, srv, key, "DELETE", "/timers/"+created.ID, ""); code != http.StatusNoContent {
+		t.Fatalf("delete: status %d", code)
+	}
+	if code, _ = apiCall(t, srv, key, "GET", "/timers/"+created.ID, ""); code != http.StatusNotFound {
+		t.Errorf("get deleted: status %d, want 404", code)
+	}
+	if code, _ = apiCall(t, srv, key, "DELETE", "/timers/"+created.ID, ""); code != http.StatusNotFound {
+		t.Errorf("delete twice: status %d, want 404", code)
+	}
+}
+
+func TestAPIForeignTimers(t *testing.T) {
+	srv, store, key := newTestAPI(t, 1)
+	ctx := context.Background()
+	other := &timer.Timer{ChatID: 2, At: time.Now().Add(time.Hour), Body: "other chat"}
+	trigger := &timer.Timer{ChatID: 1, Body: "server is up", Trigger: "up"}
+	for _, tm := range []*timer.Timer{other, trigger} {
+		if err := store.SaveTimer(ctx, tm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, id := range []string{other.ID, trigger.ID} {
+		if code, _ := apiCall(t, srv, key, "GET", "/timers/"+id, ""); code != http.StatusNotFound {
+			t.Errorf("get %s: status %d, want 404", id, code)
+		}
+		if code, _ := apiCall(t, srv, key, "PATCH", "/timers/"+id, `{"in": "2h", "body": "mine"}`); code != http.StatusNotFound {
+			t.Errorf("patch %s: status %d, want 404", id, code)
+		}
+	}
+	if code, _ := apiCall(t, srv, key, "DELETE", "/timers/"+other.ID, ""); code != http.StatusNotFound {
+		t.Errorf("delete other chat timer: status %d, want 404", code)
+	}
+	if code, data := apiCall(t, srv, key, "GET", "/timers", ""); code != http.StatusOK || string(data) != "[]" {
+		t.Errorf("list: status %d: %s, want only own regular timers", code, data)
+	}
+	if stored, err := store.GetTimerByChatAndID(ctx, 2, other.ID); err != nil || stored.Body != "other chat" {
+		t.Errorf("other chat timer changed: %+v, %v", stored, err)
+	}
+}
+
+func TestAPIPatchClaimed(t *testing.T) {
+	srv, store, key := newTestAPI(t, 1)
+	tm := &timer.Timer{ChatID: 1, At: time.Now().Add(time.Hour), Body: "feed pigs"}
+	if err := store.SaveTimer(context.Background(), tm); err != nil {
+		t.Fatal(err)
+	}
+	// scheduler claims the timer right after API read it
+	store.onGet = func(stored *timer.Timer) {
+		stored.Due = time.Now().Add(time.Minute)
+	}
+	if code, data := apiCall(t, srv, key, "PATCH", "/timers/"+tm.ID, `{"body": "feed cows"}`); code != http.StatusConflict {
+		t.Errorf("patch claimed timer: status %d: %s, want 409", code, data)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/mementor/hafenbot/storage"
+	"github.com/mementor/hafenbot/timer"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// reUsernameMention matches @username, Telegram usernames are 5-32 characters
+var reUsernameMention = regexp.MustCompile(`@(\w{5,32})`)
+
+// parseAssignees collects users mentioned in timer text: @username mentions
+// and mentions of users without username, which come as message entities.
+// The bot itself is skipped
+func parseAssignees(msg *tgbotapi.Message, text string, botName string) (assignees []timer.Assignee) {
+	seen := make(map[string]bool)
+	for _, m := range reUsernameMention.FindAllStringSubmatch(text, -1) {
+		username := strings.ToLower(m[1])
+		if seen[username] || strings.EqualFold(username, botName) {
+			continue
+		}
+		seen[username] = true
+		assignees = append(assignees, timer.Assignee{Username: username})
+	}
+	if msg.Entities == nil {
+		return assignees
+	}
+	for _, e := range *msg.Entities {
+		if e.Type != "text_mention" || e.User == nil {
+			continue
+		}
+		key := fmt.Sprintf("%d", e.User.ID)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		assignees = append(assignees, timer.Assignee{
+			UserID:   e.User.ID,
+			Username: strings.ToLower(e.User.UserName),
+			Name:     userName(e.User),
+		})
+	}
+	return assignees
+}
+
+// formatAssignees lists assignees as plain text
+func formatAssignees(assignees []timer.Assignee) string {
+	names := make([]string, 0, len(assignees))
+	for _, a := range assignees {
+		if a.Username != "" {
+			names = append(names, "@"+a.Username)
+		} else {
+			names = append(names, a.Name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// addMentions switches message to HTML and appends mentions of timer
+// assignees and, if withCreator is set, of timer creator
+func addMentions(msg *tgbotapi.MessageConfig, t *timer.Timer, withCreator bool) {
+	var mentions []string
+	creatorAssigned := false
+	for _, a := range t.Assignees {
+		if a.UserID != 0 {
+			mentions = append(mentions, mention(a.UserID, a.Name))
+		} else {
+			mentions = append(mentions, html.EscapeString("@"+a.Username))
+		}
+		if a.UserID == t.CreatorID || (a.Username != "" && strings.EqualFold(a.Username, t.CreatorName)) {
+			creatorAssigned = true
+		}
+	}
+	if withCreator && t.CreatorID != 0 && !creatorAssigned {
+		mentions = append(mentions, mention(t.CreatorID, t.CreatorName))
+	}
+	if len(mentions) == 0 {
+		return
+	}
+	msg.Text = html.EscapeString(msg.Text) + "\n👤 " + strings.Join(mentions, ", ")
+	msg.ParseMode = tgbotapi.ModeHTML
+}
+
+// handleMyTimers lists timers assigned to the user. Private chat shows
+// timers of all chats, group chat only its own ones
+func handleMyTimers(ctx context.Context, bot *tgbotapi.BotAPI, dbstore storage.Storage, msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	timers, err := dbstore.ListAssignedTimers(ctx, msg.From.ID, strings.ToLower(msg.From.UserName))
+	if err != nil {
+		logger(ctx).Error("can't list assigned timers", "err", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "error: can't list timers, try again later"))
+		return
+	}
+	private := msg.Chat.IsPrivate()
+
+	var reply bytes.Buffer
+	titles := make(map[int64]string)
+	for _, t := range timers {
+		if !private && t.ChatID != chatID {
+			continue
+		}
+		if t.Trigger != "" {
+			reply.WriteString(fmt.Sprintf("⚡ %s\n%s\n", describeTrigger(t.Trigger), t.Body))
+		} else {
+			reply.WriteString(fmt.Sprintf("⏲ %s\n%s\n", t.At.In(location).Format("2006-01-02 15:04:05 MST"), t.Body))
+		}
+		if private {
+			reply.WriteString(fmt.Sprintf("💬 %s\n", chatTitle(bot, t.ChatID, titles)))
+		}
+		reply.WriteString(t.ID + "\n\n")
+	}
+	if reply.Len() == 0 {
+		reply.WriteString("No timers assigned to you\n")
+	}
+	if !private {
+		reply.WriteString("/mytimers in private chat with me lists timers of all chats")
+	}
+	bot.Send(tgbotapi.NewMessage(chatID, reply.String()))
+}
+
+// chatTitle returns name of chat, looked up chats are kept in cache
+func chatTitle(bot *tgbotapi.BotAPI, chatID int64, cache map[int64]string) string {
+	if title, ok := cache[chatID]; ok {
+		return title
+	}
+	title := fmt.Sprintf("chat %d", chatID)
+	chat, err := bot.GetChat(tgbotapi.ChatConfig{ChatID: chatID})
+	if err == nil && chat.IsPrivate() {
+		title = "private chat"
+	} else if err == nil && chat.Title != "" {
+		title = chat.Title
+	}
+	cache[chatID] = title
+	return title
+}
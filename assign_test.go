@@ -0,0 +1,63 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mementor/hafenbot/timer"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+func TestParseAssignees(t *testing.T) {
+	anna := &tgbotapi.User{ID: 42, FirstName: "Anna", LastName: "Berg"}
+	boris := &tgbotapi.User{ID: 43, FirstName: "Boris", UserName: "Boris_B"}
+	tests := []struct {
+		name     string
+		text     string
+		entities []tgbotapi.MessageEntity
+		want     []timer.Assignee
+	}{
+		{name: "none", text: "feed pigs"},
+		{
+			name: "usernames",
+			text: "@Alice_1 and @bob_the_builder feed pigs",
+			want: []timer.Assignee{{Username: "alice_1"}, {Username: "bob_the_builder"}},
+		},
+		{
+			name: "duplicates ignore case",
+			text: "@alice_1 feed pigs @ALICE_1",
+			want: []timer.Assignee{{Username: "alice_1"}},
+		},
+		{name: "too short username", text: "@abc feed pigs"},
+		{name: "bot itself", text: "@HafenBot remind @alice_1", want: []timer.Assignee{{Username: "alice_1"}}},
+		{
+			name:     "text mention",
+			text:     "Anna feed pigs",
+			entities: []tgbotapi.MessageEntity{{Type: "text_mention", Offset: 0, Length: 4, User: anna}},
+			want:     []timer.Assignee{{UserID: 42, Name: "Anna Berg"}},
+		},
+		{
+			name: "text mention with username",
+			text: "Boris and Anna feed pigs",
+			entities: []tgbotapi.MessageEntity{
+				{Type: "text_mention", Offset: 0, Length: 5, User: boris},
+				{Type: "bold", Offset: 10, Length: 4},
+				{Type: "text_mention", Offset: 10, Length: 4, User: anna},
+				{Type: "text_mention", Offset: 10, Length: 4, User: anna},
+			},
+			want: []timer.Assignee{{UserID: 43, Username: "boris_b", Name: "Boris_B"}, {UserID: 42, Name: "Anna Berg"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &tgbotapi.Message{Text: "/timer " + tt.text + " 15m"}
+			if tt.entities != nil {
+				msg.Entities = &tt.entities
+			}
+			got := parseAssignees(msg, tt.text, "HafenBot")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mementor/hafenbot/settings"
+	"github.com/mementor/hafenbot/storage"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+const (
+	// clientTimeout limits fetch of client manifest, jars take a while
+	clientTimeout = 2 * time.Minute
+	// maxClientManifest limits size of manifest searched for version
+	maxClientManifest = 5 << 20
+	// clientWatermark is the name last seen client version is saved under
+	clientWatermark = "client"
+)
+
+// clientUpdate is a change of client version
+type clientUpdate struct {
+	Old string
+	New string
+	// At is Last-Modified time of manifest or time the change was seen
+	At  time.Time
+	URL string
+}
+
+// seen saves version of update as last seen one
+func (u clientUpdate) seen(ctx context.Context, dbstore storage.Storage) error {
+	return dbstore.SaveWatermark(ctx, clientWatermark, u.New)
+}
+
+// clientWatcher polls client manifest and passes version changes to found.
+// Version is the first submatch of versionRe in manifest, or hash of the
+// whole manifest without versionRe, e.g. for a jar
+type clientWatcher struct {
+	url       string
+	versionRe *regexp.Regexp
+	client    *http.Client
+	store     storage.Storage
+	leader    *leadership
+	found     chan clientUpdate
+}
+
+// newClientWatcher returns watcher of manifestURL, nil if it is empty
+func newClientWatcher(manifestURL string, versionRe *regexp.Regexp, store storage.Storage, leader *leadership) *clientWatcher {
+	if manifestURL == "" {
+		return nil
+	}
+	return &clientWatcher{
+		url:       manifestURL,
+		versionRe: versionRe,
+		client:    &http.Client{Timeout: clientTimeout},
+		store:     store,
+		leader:    leader,
+		found:     make(chan clientUpdate),
+	}
+}
+
+// run checks client version every interval while this instance is leader
+func (w *clientWatcher) run(shutdown context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if w.leader.IsLeader() {
+			ctx, cancel := context.WithTimeout(shutdown, clientTimeout+2*storageTimeout)
+			update, changed, err := w.check(ctx)
+			cancel()
+			if err != nil {
+				slog.Warn("client version check failed", "url", w.url, "err", err)
+			} else if changed {
+				select {
+				case w.found <- update:
+				case <-shutdown.Done():
+					return
+				}
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-shutdown.Done():
+			return
+		}
+	}
+}
+
+// check fetches client version and compares it with the saved one. The
+// first check only saves the version, later ones leave it to the receiver
+// of the update
+func (w *clientWatcher) check(ctx context.Context) (clientUpdate, bool, error) {
+	version, modified, err := w.fetch(ctx)
+	if err != nil {
+		return clientUpdate{}, false, err
+	}
+	last, err := w.store.GetWatermark(ctx, clientWatermark)
+	first := errors.Is(err, storage.ErrNotFound)
+	if err != nil && !first {
+		return clientUpdate{}, false, err
+	}
+	if version == last {
+		return clientUpdate{}, false, nil
+	}
+	update := clientUpdate{Old: last, New: version, At: modified, URL: w.url}
+	if first {
+		if err = update.seen(ctx, w.store); err != nil {
+			return clientUpdate{}, false, err
+		}
+		slog.Info("client watcher started", "url", w.url, "version", version)
+		return clientUpdate{}, false, nil
+	}
+	slog.Info("client version changed", "old", last, "new", version)
+	return update, true, nil
+}
+
+// fetch downloads manifest and returns its version and modification time
+func (w *clientWatcher) fetch(ctx context.Context) (string, time.Time, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, w.url, nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("client manifest: %s", resp.Status)
+	}
+	modified, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		modified = time.Now()
+	}
+
+	if w.versionRe == nil {
+		h := sha256.New()
+		if _, err = io.Copy(h, resp.Body); err != nil {
+			return "", time.Time{}, err
+		}
+		return "sha256:" + hex.EncodeToString(h.Sum(nil))[:16], modified, nil
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxClientManifest))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	m := w.versionRe.FindSubmatch(data)
+	if m == nil {
+		return "", time.Time{}, errors.New("no version in client manifest")
+	}
+	version := m[0]
+	if len(m) > 1 {
+		version = m[1]
+	}
+	return strings.TrimSpace(string(version)), modified, nil
+}
+
+// broadcastClient queues client update to subscribed chats in their zones
+func broadcastClient(ctx context.Context, dbstore storage.Storage, out *outbox, update clientUpdate) error {
+	chats, err := dbstore.GetClientChats(ctx)
+	if err != nil {
+		return fmt.Errorf("can't get client update subscribers: %w", err)
+	}
+	list, err := dbstore.ListChatSettings(ctx)
+	if err != nil {
+		logger(ctx).Warn("can't list chat settings, using default zone", "err", err)
+	}
+	byChat := make(map[int64]*settings.Chat)
+	for i := range list {
+		byChat[list[i].ChatID] = &list[i]
+	}
+	for _, chatID := range chats {
+		text := fmt.Sprintf("🆕 game client updated\nversion: %s\nwas: %s\nchanged at %s\n%s", update.New, update.Old,
+			update.At.In(chatLocation(byChat[chatID])).Format("2006-01-02 15:04:05 MST"), update.URL)
+		out.Send(chatID, tgbotapi.NewMessage(chatID, text))
+	}
+	logger(ctx).Info("client update posted", "version", update.New, "chats", len(chats))
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// manifestServer serves body as client manifest
+type manifestServer struct {
+	mu       sync.Mutex
+	body     string
+	modified time.Time
+}
+
+func (m *manifestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.body == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !m.modified.IsZero() {
+		w.Header().Set("Last-Modified", m.modified.UTC().Format(http.TimeFormat))
+	}
+	w.Write([]byte(m.body))
+}
+
+func (m *manifestServer) set(body string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.body = body
+}
+
+func TestClientWatcherFetch(t *testing.T) {
+	manifest := &manifestServer{modified: time.Date(2026, 10, 19, 9, 30, 0, 0, time.UTC)}
+	srv := httptest.NewServer(manifest)
+	defer srv.Close()
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		re      string
+		body    string
+		want    string
+		wantErr bool
+	}{
+		{name: "submatch", re: `version=(.+)`, body: "name=hafen\nversion= 1.2.3 \n", want: "1.2.3"},
+		{name: "whole match", re: `v\d+\.\d+`, body: "client v2.10 build 7", want: "v2.10"},
+		{name: "no version", re: `version=(\S+)`, body: "name=hafen\n", wantErr: true},
+		{name: "hash", body: "jar bytes", want: "sha256:fba6a4ac61e440c7"},
+		{name: "not found", re: `version=(\S+)`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var re *regexp.Regexp
+			if tt.re != "" {
+				re = regexp.MustCompile(tt.re)
+			}
+			manifest.set(tt.body)
+			w := newClientWatcher(srv.URL, re, newMemStore(), nil)
+			got, modified, err := w.fetch(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("version %q, want %q", got, tt.want)
+			}
+			if !modified.Equal(manifest.modified) {
+				t.Errorf("modified %v, want Last-Modified %v", modified, manifest.modified)
+			}
+		})
+	}
+}
+
+func TestClientWatcherCheck(t *testing.T) {
+	manifest := &manifestServer{}
+	srv := httptest.NewServer(manifest)
+	defer srv.Close()
+	ctx := context.Background()
+	store := newMemStore()
+	w := newClientWatcher(srv.URL, nil, store, nil)
+
+	// the first check only remembers the version
+	manifest.set("jar 1")
+	if update, changed, err := w.check(ctx); err != nil || changed {
+		t.Fatalf("first check: got %+v, %v, %v", update, changed, err)
+	}
+	first, err := store.GetWatermark(ctx, clientWatermark)
+	if err != nil || !strings.HasPrefix(first, "sha256:") {
+		t.Fatalf("first check saved %q, %v", first, err)
+	}
+	if _, changed, err := w.check(ctx); err != nil || changed {
+		t.Errorf("same jar: changed %v, %v", changed, err)
+	}
+
+	manifest.set("jar 2")
+	update, changed, err := w.check(ctx)
+	if err != nil || !changed || update.Old != first || update.New == first || update.URL != srv.URL {
+		t.Fatalf("new jar: got %+v, %v, %v", update, changed, err)
+	}
+	// nothing is saved before the update is posted
+	if got, _ := store.GetWatermark(ctx, clientWatermark); got != first {
+		t.Errorf("check saved %q before posting", got)
+	}
+	if again, changed, _ := w.check(ctx); !changed || again.New != update.New {
+		t.Errorf("unposted update: got %+v, %v on next check, want it again", again, changed)
+	}
+	if err = update.seen(ctx, store); err != nil {
+		t.Fatal(err)
+	}
+	if _, changed, err = w.check(ctx); err != nil || changed {
+		t.Errorf("after posting: changed %v, %v", changed, err)
+	}
+
+	manifest.set("")
+	if _, _, err = w.check(ctx); err == nil {
+		t.Error("missing manifest: want error")
+	}
+}
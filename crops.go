@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mementor/hafenbot/metrics"
+	"github.com/mementor/hafenbot/plot"
+	"github.com/mementor/hafenbot/storage"
+	"github.com/mementor/hafenbot/timer"
+	uuid "github.com/satori/go.uuid"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+	yaml "gopkg.in/yaml.v3"
+)
+
+//go:embed crops.yaml
+var defaultCrops []byte
+
+// crops is the data used by /crop, loaded on start
+var crops *cropDB
+
+// harvestedPlotKeep is how long /crops shows plot after it is ready
+const harvestedPlotKeep = 24 * time.Hour
+
+// crop is a plant with growth stages, the last stage is harvest
+type crop struct {
+	Name    string      `yaml:"name"`
+	Aliases []string    `yaml:"aliases"`
+	Stages  []cropStage `yaml:"stages"`
+}
+
+type cropStage struct {
+	Name  string `yaml:"name"`
+	After string `yaml:"after"`
+
+	dur time.Duration
+}
+
+type cropDB struct {
+	list []*crop
+	// byName holds crops by lowercase names and aliases
+	byName map[string]*crop
+}
+
+// loadCrops parses YAML list of crops and checks it
+func loadCrops(data []byte) (*cropDB, error) {
+	var list []*crop
+	if err := yaml.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("bad crops: %w", err)
+	}
+	db := &cropDB{list: list, byName: make(map[string]*crop)}
+	for i, c := range list {
+		if c.Name == "" {
+			return nil, fmt.Errorf("bad crops: #%d has no name", i+1)
+		}
+		if len(c.Stages) == 0 {
+			return nil, fmt.Errorf("bad crops: '%s' has no stages", c.Name)
+		}
+		var prev time.Duration
+		for j := range c.Stages {
+			s := &c.Stages[j]
+			dur, err := parseDuration(s.After)
+			if err != nil || dur <= prev {
+				return nil, fmt.Errorf("bad crops: '%s' stage '%s' has bad time '%s', stages must be in growth order", c.Name, s.Name, s.After)
+			}
+			s.dur, prev = dur, dur
+		}
+		for _, name := range append([]string{c.Name}, c.Aliases...) {
+			name = strings.ToLower(name)
+			if other, ok := db.byName[name]; ok {
+				return nil, fmt.Errorf("bad crops: '%s' is used by '%s' and '%s'", name, other.Name, c.Name)
+			}
+			db.byName[name] = c
+		}
+	}
+	if len(list) == 0 {
+		return nil, errors.New("bad crops: no crops")
+	}
+	return db, nil
+}
+
+// find returns crop by name or alias
+func (db *cropDB) find(name string) (*crop, error) {
+	if c, ok := db.byName[strings.ToLower(name)]; ok {
+		return c, nil
+	}
+	names := make([]string, 0, len(db.list))
+	for _, c := range db.list {
+		names = append(names, c.Name)
+	}
+	return nil, fmt.Errorf("no crop '%s', known ones: %s", name, strings.Join(names, ", "))
+}
+
+// freePlotName returns "<crop> <n>" not used by plots yet. It is
+// normalized like plot names typed by users, so /cropdel finds it
+func freePlotName(cropName string, plots []plot.Plot) string {
+	used := make(map[string]bool)
+	for _, p := range plots {
+		used[p.Name] = true
+	}
+	cropName = strings.ToLower(strings.Join(strings.Fields(cropName), " "))
+	for n := 1; ; n++ {
+		name := fmt.Sprintf("%s %d", cropName, n)
+		if !used[name] {
+			return name
+		}
+	}
+}
+
+// handleCrop plants a plot and schedules timer for every growth stage:
+// /crop <type> [plot name]
+func handleCrop(ctx context.Context, bot *tgbotapi.BotAPI, dbstore storage.Storage, reload chan bool, msg *tgbotapi.Message, args string) {
+	chatID := msg.Chat.ID
+	cropName, plotName, _ := strings.Cut(strings.TrimSpace(args), " ")
+	if cropName == "" {
+		bot.Send(tgbotapi.NewMessage(chatID, "usage: /crop <type> [plot name], e.g. /crop flax north field"))
+		return
+	}
+	c, err := crops.find(cropName)
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("error: %s", err)))
+		return
+	}
+	plotName = strings.ToLower(strings.Join(strings.Fields(plotName), " "))
+	if plotName == "" {
+		plots, err := dbstore.ListChatPlots(ctx, chatID)
+		if err != nil {
+			logger(ctx).Error("can't list plots", "err", err)
+			bot.Send(tgbotapi.NewMessage(chatID, "error: can't list plots, try again later"))
+			return
+		}
+		plotName = freePlotName(c.Name, plots)
+	}
+
+	now := time.Now()
+	p := &plot.Plot{ChatID: chatID, Name: plotName, Crop: c.Name, Planted: now}
+	var timers []*timer.Timer
+	for i, s := range c.Stages {
+		body := fmt.Sprintf("🌱 %s: %s reached %s (%d/%d)", plotName, c.Name, s.Name, i+1, len(c.Stages))
+		if i == len(c.Stages)-1 {
+			body = fmt.Sprintf("🌾 %s: %s is ready to harvest", plotName, c.Name)
+		}
+		t := &timer.Timer{
+			At:          now.Add(s.dur),
+			Body:        body,
+			ChatID:      chatID,
+			ID:          fmt.Sprintf("%s", uuid.NewV4()),
+			CreatorID:   msg.From.ID,
+			CreatorName: userName(msg.From),
+		}
+		timers = append(timers, t)
+		p.Stages = append(p.Stages, plot.Stage{Name: s.Name, At: t.At})
+		p.TimerIDs = append(p.TimerIDs, t.ID)
+	}
+
+	err = dbstore.SavePlot(ctx, p)
+	if errors.Is(err, storage.ErrConflict) {
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Plot '%s' already exists\n/cropdel %s to remove it", plotName, plotName)))
+		return
+	}
+	if err != nil {
+		logger(ctx).Error("can't save plot", "err", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "error: can't save plot, try again later"))
+		return
+	}
+	for _, t := range timers {
+		if err = dbstore.SaveTimer(ctx, t); err != nil {
+			logger(ctx).Error("can't save stage timer", "plot", plotName, "err", err)
+			if err = removePlot(ctx, dbstore, p); err != nil {
+				logger(ctx).Warn("can't roll back plot", "plot", plotName, "err", err)
+			}
+			reload <- true
+			bot.Send(tgbotapi.NewMessage(chatID, "error: can't save plot, try again later"))
+			return
+		}
+		metrics.TimersCreated.WithLabelValues("crop").Inc()
+	}
+	logger(ctx).Info("plot planted", "plot", plotName, "crop", c.Name)
+	reload <- true
+
+	var reply bytes.Buffer
+	reply.WriteString(fmt.Sprintf("🌱 %s: %s planted\n", plotName, c.Name))
+	for _, s := range p.Stages {
+		reply.WriteString(fmt.Sprintf("⏲ %s %s\n", s.At.In(location).Format("2006-01-02 15:04 MST"), s.Name))
+	}
+	bot.Send(tgbotapi.NewMessage(chatID, reply.String()))
+}
+
+// removePlot deletes plot and its stage timers which haven't fired yet
+func removePlot(ctx context.Context, dbstore storage.Storage, p *plot.Plot) error {
+	for _, id := range p.TimerIDs {
+		err := dbstore.DeleteTimer(ctx, p.ChatID, id)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+	}
+	return dbstore.DeletePlot(ctx, p.ChatID, p.Name)
+}
+
+// handleCrops lists plots with their current stage and ETA. Plots harvested
+// long ago are dropped
+func handleCrops(ctx context.Context, bot *tgbotapi.BotAPI, dbstore storage.Storage, chatID int64) {
+	plots, err := dbstore.ListChatPlots(ctx, chatID)
+	if err != nil {
+		logger(ctx).Error("can't list plots", "err", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "error: can't list plots, try again later"))
+		return
+	}
+
+	now := time.Now()
+	var reply bytes.Buffer
+	for i := range plots {
+		p := &plots[i]
+		if now.Sub(p.Ready()) > harvestedPlotKeep {
+			if err = dbstore.DeletePlot(ctx, chatID, p.Name); err != nil && !errors.Is(err, storage.ErrNotFound) {
+				logger(ctx).Warn("can't drop harvested plot", "plot", p.Name, "err", err)
+			}
+			continue
+		}
+		cur := p.Current(now)
+		stage := "planted"
+		if cur > 0 {
+			stage = p.Stages[cur-1].Name
+		}
+		reply.WriteString(fmt.Sprintf("🌾 %s: %s\nstage %d/%d: %s\n", p.Name, p.Crop, cur, len(p.Stages), stage))
+		if cur == len(p.Stages) {
+			reply.WriteString(fmt.Sprintf("ready to harvest since %s\n\n", p.Ready().In(location).Format("2006-01-02 15:04 MST")))
+			continue
+		}
+		next := p.Stages[cur]
+		reply.WriteString(fmt.Sprintf("next: %s in %s\n", next.Name, formatDuration(next.At.Sub(now))))
+		reply.WriteString(fmt.Sprintf("ready at %s (in %s)\n\n", p.Ready().In(location).Format("2006-01-02 15:04 MST"), formatDuration(p.Ready().Sub(now))))
+	}
+	if reply.Len() == 0 {
+		reply.WriteString("No plots here yet\n/crop <type> [plot name] to plant one")
+	}
+	bot.Send(tgbotapi.NewMessage(chatID, reply.String()))
+}
+
+// handleCropDel removes plot with its pending timers: /cropdel <plot name>
+func handleCropDel(ctx context.Context, bot *tgbotapi.BotAPI, dbstore storage.Storage, reload chan bool, chatID int64, args string) {
+	name := strings.ToLower(strings.Join(strings.Fields(args), " "))
+	plots, err := dbstore.ListChatPlots(ctx, chatID)
+	if err != nil {
+		logger(ctx).Error("can't list plots", "err", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "error: can't list plots, try again later"))
+		return
+	}
+	for i := range plots {
+		if plots[i].Name != name {
+			continue
+		}
+		if err = removePlot(ctx, dbstore, &plots[i]); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			logger(ctx).Error("can't delete plot", "plot", name, "err", err)
+			bot.Send(tgbotapi.NewMessage(chatID, "error: can't delete plot, try again later"))
+			return
+		}
+		logger(ctx).Info("plot deleted", "plot", name)
+		reload <- true
+		bot.Send(tgbotapi.NewMessage(chatID, "Done!"))
+		return
+	}
+	bot.Send(tgbotapi.NewMessage(chatID, "No such plot\n/crops lists them"))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mementor/hafenbot/plot"
+)
+
+func TestLoadCrops(t *testing.T) {
+	db, err := loadCrops(defaultCrops)
+	if err != nil {
+		t.Fatalf("default crops: %s", err)
+	}
+	if len(db.list) == 0 {
+		t.Fatal("default crops are empty")
+	}
+
+	db, err = loadCrops([]byte(`
+- name: Red Onion
+  aliases: [onion]
+  stages:
+    - {name: sprout, after: 12h}
+    - {name: ready, after: 1d6h}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"red onion", "RED ONION", "Onion"} {
+		c, err := db.find(name)
+		if err != nil || c.Name != "Red Onion" {
+			t.Errorf("find(%q) = %v, %v", name, c, err)
+		}
+	}
+	if _, err = db.find("carrot"); err == nil || !strings.Contains(err.Error(), "Red Onion") {
+		t.Errorf("find(carrot) error %v does not list known crops", err)
+	}
+	c, _ := db.find("onion")
+	if c.Stages[0].dur != 12*time.Hour || c.Stages[1].dur != 30*time.Hour {
+		t.Errorf("stage durations %s, %s", c.Stages[0].dur, c.Stages[1].dur)
+	}
+}
+
+func TestLoadCropsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"not yaml", "- name: [", "bad crops"},
+		{"empty", "[]", "no crops"},
+		{"no name", "- stages: [{name: ready, after: 1d}]", "#1 has no name"},
+		{"no stages", "- name: flax", "has no stages"},
+		{"bad time", "- name: flax\n  stages: [{name: ready, after: soon}]", "bad time 'soon'"},
+		{"out of order", "- name: flax\n  stages: [{name: ready, after: 2d}, {name: sprout, after: 1d}]", "growth order"},
+		{"duplicate alias", "- name: flax\n  stages: [{name: ready, after: 1d}]\n- name: hemp\n  aliases: [Flax]\n  stages: [{name: ready, after: 1d}]", "'flax' is used by 'flax' and 'hemp'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadCrops([]byte(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFreePlotName(t *testing.T) {
+	plots := []plot.Plot{{Name: "red onion 1"}, {Name: "red onion 3"}, {Name: "flax 1"}}
+	tests := []struct {
+		crop  string
+		plots []plot.Plot
+		want  string
+	}{
+		{"flax", nil, "flax 1"},
+		{"flax", plots, "flax 2"},
+		{"Red  Onion", plots, "red onion 2"},
+		{"hemp", plots, "hemp 1"},
+	}
+	for _, tt := range tests {
+		if got := freePlotName(tt.crop, tt.plots); got != tt.want {
+			t.Errorf("freePlotName(%q) = %q, want %q", tt.crop, got, tt.want)
+		}
+	}
+}
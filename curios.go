@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mementor/hafenbot/metrics"
+	"github.com/mementor/hafenbot/storage"
+	"github.com/mementor/hafenbot/timer"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+	yaml "gopkg.in/yaml.v3"
+)
+
+//go:embed curios.yaml
+var defaultCurios []byte
+
+// curios is the database used by /curio, loaded on start
+var curios *curioDB
+
+// maxCurioCount limits number of curios studied at once by /curio
+const maxCurioCount = 20
+
+// curio is a curiosity which gives learning points when studied
+type curio struct {
+	Name      string   `yaml:"name"`
+	Aliases   []string `yaml:"aliases"`
+	Time      string   `yaml:"time"`
+	Attention int      `yaml:"attention"`
+	LP        int      `yaml:"lp"`
+
+	dur time.Duration
+}
+
+type curioDB struct {
+	list []*curio
+	// byName holds curios by lowercase names and aliases
+	byName map[string]*curio
+}
+
+// loadCurios parses YAML list of curiosities and checks it
+func loadCurios(data []byte) (*curioDB, error) {
+	var list []*curio
+	if err := yaml.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("bad curios: %w", err)
+	}
+	db := &curioDB{list: list, byName: make(map[string]*curio)}
+	for i, c := range list {
+		if c.Name == "" {
+			return nil, fmt.Errorf("bad curios: #%d has no name", i+1)
+		}
+		if c.LP <= 0 || c.Attention <= 0 {
+			return nil, fmt.Errorf("bad curios: '%s' needs positive lp and attention", c.Name)
+		}
+		dur, err := parseDuration(c.Time)
+		if err != nil || dur <= 0 {
+			return nil, fmt.Errorf("bad curios: '%s' has bad time '%s'", c.Name, c.Time)
+		}
+		c.dur = dur
+		for _, name := range c.names() {
+			if other, ok := db.byName[name]; ok {
+				return nil, fmt.Errorf("bad curios: '%s' is used by '%s' and '%s'", name, other.Name, c.Name)
+			}
+			db.byName[name] = c
+		}
+	}
+	if len(list) == 0 {
+		return nil, errors.New("bad curios: no curios")
+	}
+	return db, nil
+}
+
+// find returns curio by name or alias. Failing that, names containing the
+// query and then names within a couple of typos are tried
+func (db *curioDB) find(name string) (*curio, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if c, ok := db.byName[name]; ok {
+		return c, nil
+	}
+	var found []*curio
+	for _, c := range db.list {
+		for _, key := range c.names() {
+			if strings.Contains(key, name) {
+				found = append(found, c)
+				break
+			}
+		}
+	}
+	if len(found) == 1 {
+		return found[0], nil
+	}
+	if len(found) > 1 {
+		names := make([]string, 0, len(found))
+		for _, c := range found {
+			names = append(names, c.Name)
+		}
+		return nil, fmt.Errorf("'%s' matches %s, be more specific", name, strings.Join(names, ", "))
+	}
+
+	var best *curio
+	bestDist := 3
+	for _, c := range db.list {
+		for _, key := range c.names() {
+			if d := levenshtein(key, name); d < bestDist {
+				best, bestDist = c, d
+			}
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no curio '%s'", name)
+	}
+	return best, nil
+}
+
+// names returns lowercase name and aliases of curio
+func (c *curio) names() []string {
+	names := []string{strings.ToLower(c.Name)}
+	for _, alias := range c.Aliases {
+		names = append(names, strings.ToLower(alias))
+	}
+	return names
+}
+
+// levenshtein returns edit distance between a and b
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// parseCurioArgs splits /curio arguments into curio name and count. Trailing
+// number is the count when there is a name before it
+func parseCurioArgs(args string) (name string, count int, err error) {
+	words := strings.Fields(args)
+	count = 1
+	if len(words) > 1 {
+		if n, err := strconv.Atoi(words[len(words)-1]); err == nil {
+			count = n
+			words = words[:len(words)-1]
+		}
+	}
+	if count < 1 || count > maxCurioCount {
+		return "", 0, fmt.Errorf("count must be 1 to %d", maxCurioCount)
+	}
+	return strings.Join(words, " "), count, nil
+}
+
+// handleCurio creates timer for the end of curio study: /curio <name> [count].
+// count curios are studied at once, so it multiplies LP and attention only
+func handleCurio(ctx context.Context, bot *tgbotapi.BotAPI, dbstore storage.Storage, reload chan bool, msg *tgbotapi.Message, args string) {
+	chatID := msg.Chat.ID
+	if strings.TrimSpace(args) == "" {
+		bot.Send(tgbotapi.NewMessage(chatID, "usage: /curio <name> [count], e.g. /curio bark boat 2"))
+		return
+	}
+	name, count, err := parseCurioArgs(args)
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("error: %s", err)))
+		return
+	}
+	c, err := curios.find(name)
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("error: %s", err)))
+		return
+	}
+
+	lp := c.LP * count
+	t := &timer.Timer{
+		At:          time.Now().Add(c.dur),
+		Body:        fmt.Sprintf("📚 %d× %s studied, +%d LP", count, c.Name, lp),
+		ChatID:      chatID,
+		CreatorID:   msg.From.ID,
+		CreatorName: userName(msg.From),
+	}
+	if err = validateTimer(t.At, t.Body, time.Now()); err != nil {
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("error: %s", err)))
+		return
+	}
+	if err = dbstore.SaveTimer(ctx, t); err != nil {
+		logger(ctx).Error("can't save timer", "err", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "error: can't save timer, try again later"))
+		return
+	}
+	logger(ctx).Info("timer created", "timer_id", t.ID, "at", t.At, "curio", c.Name, "count", count)
+	metrics.TimersCreated.WithLabelValues("curio").Inc()
+	reload <- true
+
+	reply := fmt.Sprintf("⏲ fire at %s\n%d× %s: %s, %d LP, attention %d, %.0f LP/hour",
+		t.At.In(location).Format("2006-01-02 15:04:05 MST"), count, c.Name,
+		formatDuration(c.dur), lp, c.Attention*count, float64(lp)/c.dur.Hours())
+	bot.Send(tgbotapi.NewMessage(chatID, reply))
+}
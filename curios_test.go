@@ -0,0 +1,140 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const testCurios = `
+- {name: Bark Boat, aliases: [boat], time: 40m, attention: 2, lp: 120}
+- {name: Birchbark Box, time: 2h, attention: 4, lp: 500}
+- {name: Straw Doll, aliases: [doll], time: 1h, attention: 3, lp: 200}
+- {name: Stick Bug, time: 30m, attention: 1, lp: 60}
+`
+
+func TestLoadCurios(t *testing.T) {
+	if _, err := loadCurios(defaultCurios); err != nil {
+		t.Fatalf("embedded curios: %v", err)
+	}
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{name: "zero time", yaml: "- {name: Doll, time: 0s, attention: 1, lp: 1}\n", wantErr: "bad time '0s'"},
+		{name: "no time", yaml: "- {name: Doll, attention: 1, lp: 1}\n", wantErr: "bad time ''"},
+		{name: "no lp", yaml: "- {name: Doll, time: 1h, attention: 1}\n", wantErr: "positive lp and attention"},
+		{name: "duplicate alias", yaml: "- {name: Doll, time: 1h, attention: 1, lp: 1}\n- {name: Straw, aliases: [DOLL], time: 1h, attention: 1, lp: 1}\n", wantErr: "'doll' is used by"},
+		{name: "empty", yaml: "", wantErr: "no curios"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadCurios([]byte(tt.yaml))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCurioFind(t *testing.T) {
+	db, err := loadCurios([]byte(testCurios))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		query   string
+		want    string
+		wantErr string
+	}{
+		{query: "Bark Boat", want: "Bark Boat"},
+		{query: " BOAT ", want: "Bark Boat"},
+		{query: "doll", want: "Straw Doll"},
+		// substring of a single name
+		{query: "straw", want: "Straw Doll"},
+		{query: "box", want: "Birchbark Box"},
+		// substring of several names
+		{query: "bark", wantErr: "'bark' matches Bark Boat, Birchbark Box"},
+		{query: "b", wantErr: "matches"},
+		// typos
+		{query: "stik bug", want: "Stick Bug"},
+		{query: "bark baot", want: "Bark Boat"},
+		{query: "dolly", want: "Straw Doll"},
+		{query: "straw dog", want: "Straw Doll"},
+		{query: "stick bugs!!", wantErr: "no curio 'stick bugs!!'"},
+		{query: "mammoth", wantErr: "no curio"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			c, err := db.find(tt.query)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Name != tt.want {
+				t.Errorf("got '%s', want '%s'", c.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"", "boat", 4},
+		{"boat", "boat", 0},
+		{"boat", "baot", 2},
+		{"boat", "bot", 1},
+		{"boat", "boats", 1},
+		{"boat", "coat", 1},
+		{"kitten", "sitting", 3},
+		{"ёлка", "елка", 1},
+	}
+	for _, tt := range tests {
+		if got := levenshtein(tt.a, tt.b); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := levenshtein(tt.b, tt.a); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestParseCurioArgs(t *testing.T) {
+	tests := []struct {
+		args      string
+		wantName  string
+		wantCount int
+		wantErr   bool
+	}{
+		{args: "bark boat", wantName: "bark boat", wantCount: 1},
+		{args: "bark boat 3", wantName: "bark boat", wantCount: 3},
+		{args: "  doll   2 ", wantName: "doll", wantCount: 2},
+		{args: "doll 20", wantName: "doll", wantCount: maxCurioCount},
+		// a lone number is a name
+		{args: "7", wantName: "7", wantCount: 1},
+		{args: "doll 2x", wantName: "doll 2x", wantCount: 1},
+		{args: "doll 0", wantErr: true},
+		{args: "doll -1", wantErr: true},
+		{args: "doll 21", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			name, count, err := parseCurioArgs(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if name != tt.wantName || count != tt.wantCount {
+				t.Errorf("got '%s' ×%d, want '%s' ×%d", name, count, tt.wantName, tt.wantCount)
+			}
+		})
+	}
+}
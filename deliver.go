@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"strings"
+
+	"github.com/mementor/hafenbot/timer"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// undeliverable are descriptions of Bad Request errors meaning that the
+// chat can't be written to at all, other Bad Requests are about the message
+var undeliverable = []string{
+	"chat not found",
+	"user is deactivated",
+	"peer_id_invalid",
+}
+
+// isForbidden reports whether Telegram refused to deliver to chat for good,
+// e.g. user never started the bot, blocked it or deleted the account. The
+// library keeps only description of API errors, which starts with status
+// text of the error code
+func isForbidden(err error) bool {
+	var apiErr tgbotapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	desc := strings.ToLower(apiErr.Message)
+	if strings.HasPrefix(desc, "forbidden") {
+		return true
+	}
+	if !strings.HasPrefix(desc, "bad request") {
+		return false
+	}
+	for _, s := range undeliverable {
+		if strings.Contains(desc, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// sendToTarget sends message about the timer to its delivery target. If the
+// target is a private chat the bot can't write to, message goes to the timer
+// chat with a note asking the creator to start the bot
+func sendToTarget(bot *tgbotapi.BotAPI, t *timer.Timer, msg tgbotapi.MessageConfig, lg *slog.Logger) error {
+	msg.ChatID = t.Target()
+	_, err := bot.Send(msg)
+	if err == nil || msg.ChatID == t.ChatID || !isForbidden(err) {
+		return err
+	}
+	lg.Info("can't deliver timer privately, falling back to chat", "target", msg.ChatID, "err", err)
+	if msg.ParseMode != tgbotapi.ModeHTML {
+		msg.Text = html.EscapeString(msg.Text)
+		msg.ParseMode = tgbotapi.ModeHTML
+	}
+	note := fmt.Sprintf("⚠️ %s, I can't write to you privately, start a chat with @%s to get reminders there",
+		mention(t.CreatorID, t.CreatorName), html.EscapeString(bot.Self.UserName))
+	msg.Text = note + "\n\n" + msg.Text
+	msg.ChatID = t.ChatID
+	_, err = bot.Send(msg)
+	return err
+}
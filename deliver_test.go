@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+func TestIsForbidden(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{tgbotapi.Error{Message: "Forbidden: bot was blocked by the user"}, true},
+		{tgbotapi.Error{Message: "Forbidden: bot can't initiate conversation with a user"}, true},
+		{tgbotapi.Error{Message: "Bad Request: chat not found"}, true},
+		{tgbotapi.Error{Message: "Forbidden: user is deactivated"}, true},
+		{tgbotapi.Error{Message: "Bad Request: PEER_ID_INVALID"}, true},
+		{fmt.Errorf("send: %w", tgbotapi.Error{Message: "Bad Request: chat not found"}), true},
+		{tgbotapi.Error{Message: "Bad Request: can't parse entities"}, false},
+		{tgbotapi.Error{Message: "Too Many Requests: retry after 5"}, false},
+		{errors.New("Forbidden: not from Telegram"), false},
+		{errors.New("connection reset by peer"), false},
+	}
+	for _, tt := range tests {
+		if got := isForbidden(tt.err); got != tt.want {
+			t.Errorf("isForbidden(%q) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mementor/hafenbot/ical"
+	"github.com/mementor/hafenbot/metrics"
+	"github.com/mementor/hafenbot/storage"
+	"github.com/mementor/hafenbot/timer"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+const (
+	// maxImportSize limits size of uploaded file accepted by /import
+	maxImportSize = 1 << 20
+	// maxImportEntries limits number of timers created by single /import
+	maxImportEntries = 500
+	// maxReportedRejects limits rejected entries listed in /import summary
+	maxReportedRejects = 20
+)
+
+// exportFile is JSON document produced by /export and accepted by /import
+type exportFile struct {
+	ChatID     int64         `json:"chat_id"`
+	ExportedAt time.Time     `json:"exported_at"`
+	Timers     []exportTimer `json:"timers"`
+}
+
+type exportTimer struct {
+	ID   string    `json:"id,omitempty"`
+	At   time.Time `json:"at"`
+	Body string    `json:"body"`
+	// Warnings are lead times of pre-reminders like "30m"
+	Warnings []string `json:"warnings,omitempty"`
+	// NagEvery and NagCount repeat fired timer until done, e.g. "10m" and 3
+	NagEvery   string           `json:"nag_every,omitempty"`
+	NagCount   int              `json:"nag_count,omitempty"`
+	NagMention bool             `json:"nag_mention,omitempty"`
+	Assignees  []exportAssignee `json:"assignees,omitempty"`
+	// Private sends timer to private chat of its creator. Imported timer
+	// goes to private chat of the user importing it
+	Private bool `json:"private,omitempty"`
+	// Trigger is /when condition, At is ignored for such timers
+	Trigger      string `json:"trigger,omitempty"`
+	TriggerEvery bool   `json:"trigger_every,omitempty"`
+}
+
+type exportAssignee struct {
+	UserID   int    `json:"user_id,omitempty"`
+	Username string `json:"username,omitempty"`
+	Name     string `json:"name,omitempty"`
+}
+
+// newExportTimer returns export entry with all settings of timer
+func newExportTimer(t *timer.Timer) exportTimer {
+	e := exportTimer{
+		ID:           t.ID,
+		At:           t.At.In(location),
+		Body:         t.Body,
+		NagCount:     t.NagCount,
+		NagMention:   t.NagMention,
+		Private:      t.DeliverTo != 0 && t.DeliverTo != t.ChatID,
+		Trigger:      t.Trigger,
+		TriggerEvery: t.TriggerEvery,
+	}
+	for _, w := range t.Warnings {
+		e.Warnings = append(e.Warnings, formatDuration(w))
+	}
+	if t.NagCount > 0 {
+		e.NagEvery = formatDuration(t.NagEvery)
+	}
+	for _, a := range t.Assignees {
+		e.Assignees = append(e.Assignees, exportAssignee{UserID: a.UserID, Username: a.Username, Name: a.Name})
+	}
+	return e
+}
+
+// toTimer checks import entry the way commands check their arguments and
+// returns timer of chat created by user from
+func (e *exportTimer) toTimer(chatID int64, from *tgbotapi.User, now time.Time) (*timer.Timer, error) {
+	t := &timer.Timer{
+		At:          e.At,
+		Body:        strings.TrimSpace(e.Body),
+		ChatID:      chatID,
+		CreatorID:   from.ID,
+		CreatorName: userName(from),
+		NagMention:  e.NagMention,
+	}
+	if e.Trigger != "" {
+		trigger, err := parseTrigger(e.Trigger)
+		if err != nil {
+			return nil, err
+		}
+		if t.Body == "" {
+			return nil, errors.New("timer have no text")
+		}
+		t.At, t.Trigger, t.TriggerEvery = now, trigger, e.TriggerEvery
+	} else if err := validateTimer(t.At, t.Body, now); err != nil {
+		return nil, err
+	}
+	if len(e.Warnings) > 0 {
+		warnings, err := parseWarnings(strings.Join(e.Warnings, ","))
+		if err != nil {
+			return nil, err
+		}
+		t.Warnings = warnings
+		t.ResetWarnings(now)
+	}
+	if e.NagCount > 0 {
+		every, count, err := parseNag(fmt.Sprintf("%sx%d", e.NagEvery, e.NagCount))
+		if err != nil {
+			return nil, err
+		}
+		t.NagEvery, t.NagCount = every, count
+	}
+	for _, a := range e.Assignees {
+		t.Assignees = append(t.Assignees, timer.Assignee{UserID: a.UserID, Username: strings.ToLower(a.Username), Name: a.Name})
+	}
+	if e.Private {
+		t.DeliverTo = int64(from.ID)
+	}
+	return t, nil
+}
+
+// importKey identifies timers which are the same for /import
+func importKey(t *timer.Timer) string {
+	if t.Trigger != "" {
+		return "when|" + t.Trigger + "|" + t.Body
+	}
+	return fmt.Sprintf("%d|%s", t.At.Unix(), t.Body)
+}
+
+// handleExport sends chat timers as JSON and iCalendar documents
+func handleExport(ctx context.Context, bot *tgbotapi.BotAPI, dbstore storage.Storage, chatID int64) {
+	timers, err := dbstore.ListChatTimers(ctx, chatID)
+	if err != nil {
+		logger(ctx).Error("can't list timers", "err", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "error: can't list timers, try again later"))
+		return
+	}
+	if len(timers) == 0 {
+		bot.Send(tgbotapi.NewMessage(chatID, "No timers here yet"))
+		return
+	}
+
+	export := exportFile{ChatID: chatID, ExportedAt: time.Now().In(location)}
+	for i := range timers {
+		export.Timers = append(export.Timers, newExportTimer(&timers[i]))
+	}
+	jsonData, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		logger(ctx).Error("can't encode JSON export", "err", err)
+		return
+	}
+	var icsData bytes.Buffer
+	if err = ical.Encode(&icsData, "hafenbot timers", timers); err != nil {
+		logger(ctx).Error("can't encode iCalendar export", "err", err)
+		return
+	}
+
+	stamp := time.Now().In(location).Format("20060102-1504")
+	files := []tgbotapi.FileBytes{
+		{Name: fmt.Sprintf("timers-%s.json", stamp), Bytes: jsonData},
+		{Name: fmt.Sprintf("timers-%s.ics", stamp), Bytes: icsData.Bytes()},
+	}
+	for _, file := range files {
+		if _, err = bot.Send(tgbotapi.NewDocumentUpload(chatID, file)); err != nil {
+			logger(ctx).Error("can't send export", "file", file.Name, "err", err)
+		}
+	}
+}
+
+// importDocument returns document attached to /import or to the message it replies
+func importDocument(msg *tgbotapi.Message) *tgbotapi.Document {
+	if msg.Document != nil {
+		return msg.Document
+	}
+	if msg.ReplyToMessage != nil {
+		return msg.ReplyToMessage.Document
+	}
+	return nil
+}
+
+// handleImport creates timers from uploaded JSON or iCalendar document
+func handleImport(ctx context.Context, bot *tgbotapi.BotAPI, dbstore storage.Storage, reload chan bool, msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	doc := importDocument(msg)
+	if doc == nil {
+		bot.Send(tgbotapi.NewMessage(chatID, "send me .json or .ics file with /import caption or reply /import to such file"))
+		return
+	}
+	if doc.FileSize > maxImportSize {
+		bot.Send(tgbotapi.NewMessage(chatID, "error: file is too big"))
+		return
+	}
+
+	data, err := downloadFile(ctx, bot, doc.FileID)
+	if err != nil {
+		logger(ctx).Warn("can't download import", "err", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "error: can't download file"))
+		return
+	}
+	entries, err := decodeImport(data)
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("error: %s", err)))
+		return
+	}
+	if len(entries) > maxImportEntries {
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("error: too many timers, %d max", maxImportEntries)))
+		return
+	}
+
+	existing, err := dbstore.ListChatTimers(ctx, chatID)
+	if err != nil {
+		logger(ctx).Error("can't list timers", "err", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "error: can't list timers, try again later"))
+		return
+	}
+	seen := make(map[string]bool)
+	for i := range existing {
+		seen[importKey(&existing[i])] = true
+	}
+
+	var accepted int
+	var rejects []string
+	now := time.Now()
+	for i := range entries {
+		var reason string
+		t, err := entries[i].toTimer(chatID, msg.From, now)
+		if err != nil {
+			reason = err.Error()
+		} else if seen[importKey(t)] {
+			reason = "already exists"
+		}
+		if reason == "" {
+			if err = dbstore.SaveTimer(ctx, t); err != nil {
+				logger(ctx).Error("can't save imported timer", "err", err)
+				reason = "can't save"
+			}
+		}
+		if reason != "" {
+			rejects = append(rejects, fmt.Sprintf("#%d: %s", i+1, reason))
+			continue
+		}
+		seen[importKey(t)] = true
+		metrics.TimersCreated.WithLabelValues("import").Inc()
+		accepted++
+	}
+	if accepted > 0 {
+		reload <- true
+		watchers.invalidate()
+	}
+
+	var reply bytes.Buffer
+	reply.WriteString(fmt.Sprintf("Imported: %d\nRejected: %d\n", accepted, len(rejects)))
+	for i, r := range rejects {
+		if i == maxReportedRejects {
+			reply.WriteString(fmt.Sprintf("...and %d more\n", len(rejects)-i))
+			break
+		}
+		reply.WriteString(r + "\n")
+	}
+	bot.Send(tgbotapi.NewMessage(chatID, reply.String()))
+}
+
+// decodeImport detects file format and returns its entries
+func decodeImport(data []byte) ([]exportTimer, error) {
+	trimmed := bytes.TrimSpace(data)
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		var file exportFile
+		if err := json.Unmarshal(trimmed, &file); err != nil {
+			return nil, fmt.Errorf("bad JSON: %s", err)
+		}
+		return file.Timers, nil
+	}
+	events, err := ical.Decode(bytes.NewReader(trimmed), location)
+	if err != nil {
+		return nil, errors.New("unknown file format, expected .json or .ics export")
+	}
+	entries := make([]exportTimer, 0, len(events))
+	for _, ev := range events {
+		entries = append(entries, exportTimer{ID: ev.UID, At: ev.Start, Body: ev.Summary})
+	}
+	return entries, nil
+}
+
+// downloadFile fetches file uploaded to Telegram
+func downloadFile(ctx context.Context, bot *tgbotapi.BotAPI, fileID string) ([]byte, error) {
+	fileURL, err := bot.GetFileDirectURL(fileID)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if uerr, ok := err.(*url.Error); ok {
+		// file URL contains bot token, keep it out of logs
+		return nil, fmt.Errorf("download file: %w", uerr.Err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download file: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImportSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxImportSize {
+		return nil, errors.New("download file: file is too big")
+	}
+	return data, nil
+}
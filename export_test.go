@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mementor/hafenbot/timer"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+func TestDecodeImport(t *testing.T) {
+	at := time.Date(2026, 10, 19, 9, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		data    string
+		want    []exportTimer
+		wantErr bool
+	}{
+		{
+			name: "json",
+			data: ` {"chat_id": 1, "timers": [{"id": "a1", "at": "2026-10-19T12:30:00+03:00", "body": "feed pigs"}]}`,
+			want: []exportTimer{{ID: "a1", At: at, Body: "feed pigs"}},
+		},
+		{
+			name: "ical",
+			data: "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:a1@hafenbot\r\nDTSTART:20261019T093000Z\r\nSUMMARY:feed pigs\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+			want: []exportTimer{{ID: "a1", At: at, Body: "feed pigs"}},
+		},
+		{
+			name: "ical in bot zone",
+			data: "BEGIN:VCALENDAR\nBEGIN:VEVENT\nDTSTART:20261019T123000\nSUMMARY:feed pigs\nEND:VEVENT\nEND:VCALENDAR\n",
+			want: []exportTimer{{At: at, Body: "feed pigs"}},
+		},
+		{name: "bad json", data: `{"timers": [`, wantErr: true},
+		{name: "unknown", data: "feed pigs at 12:30", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeImport([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i].ID != tt.want[i].ID || !got[i].At.Equal(tt.want[i].At) || got[i].Body != tt.want[i].Body {
+					t.Errorf("entry %d: got %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	now := time.Now()
+	at := now.Add(2 * time.Hour).Truncate(time.Second)
+	orig := []timer.Timer{
+		{
+			ID:          "a1",
+			At:          at,
+			Body:        "feed pigs @bob",
+			ChatID:      -100,
+			CreatorID:   7,
+			CreatorName: "alice",
+			Warnings:    []time.Duration{time.Hour, 30 * time.Minute},
+			NagEvery:    10 * time.Minute,
+			NagCount:    5,
+			NagMention:  true,
+			Assignees:   []timer.Assignee{{Username: "bob"}, {UserID: 42, Name: "Carol"}},
+			DeliverTo:   7,
+		},
+		{ID: "b2", At: now.Add(-time.Hour), Body: "server is back", ChatID: -100, Trigger: "online>500", TriggerEvery: true},
+	}
+	file := exportFile{ChatID: -100, ExportedAt: now}
+	for i := range orig {
+		file.Timers = append(file.Timers, newExportTimer(&orig[i]))
+	}
+	data, err := json.Marshal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := decodeImport(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(orig) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(orig))
+	}
+
+	from := &tgbotapi.User{ID: 9, UserName: "dave"}
+	for i := range entries {
+		got, err := entries[i].toTimer(-200, from, now)
+		if err != nil {
+			t.Fatalf("entry %d: %s", i, err)
+		}
+		want := orig[i]
+		want.ID, want.ChatID, want.CreatorID, want.CreatorName = "", -200, 9, "dave"
+		if want.DeliverTo != 0 {
+			want.DeliverTo = 9
+		}
+		if want.Trigger != "" {
+			want.At = now
+		}
+		if !got.At.Equal(want.At) {
+			t.Errorf("entry %d: at %s, want %s", i, got.At, want.At)
+		}
+		got.At = want.At
+		if !reflect.DeepEqual(*got, want) {
+			t.Errorf("entry %d:\n got %+v\nwant %+v", i, *got, want)
+		}
+	}
+}
+
+func TestImportRejects(t *testing.T) {
+	now := time.Now()
+	from := &tgbotapi.User{ID: 9}
+	tests := []exportTimer{
+		{At: now.Add(-time.Minute), Body: "past"},
+		{At: now.Add(time.Hour), Body: " "},
+		{At: now.Add(time.Hour), Body: "nag", NagEvery: "10s", NagCount: 3},
+		{At: now.Add(time.Hour), Body: "nag", NagEvery: "10m", NagCount: 100},
+		{At: now.Add(time.Hour), Body: "warn", Warnings: []string{"soon"}},
+		{Body: "when", Trigger: "sometimes"},
+	}
+	for i := range tests {
+		if _, err := tests[i].toTimer(-100, from, now); err == nil {
+			t.Errorf("entry %+v accepted", tests[i])
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/mementor/hafenbot/ical"
+	"github.com/mementor/hafenbot/storage"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// publicURL is base of links to HTTP server given out to chats.
+// Empty means HTTP server is disabled
+var publicURL string
+
+func feedURL(token string) string {
+	return fmt.Sprintf("%s/ical/%s.ics", publicURL, token)
+}
+
+// handleICalFeed manages chat feed token: /icalfeed on|off|rotate
+func handleICalFeed(ctx context.Context, bot *tgbotapi.BotAPI, dbstore storage.Storage, chatID int64, arg string) {
+	if publicURL == "" {
+		bot.Send(tgbotapi.NewMessage(chatID, "iCal feed is disabled on this bot"))
+		return
+	}
+	mode := strings.ToLower(strings.TrimSpace(arg))
+	if mode != "on" && mode != "off" && mode != "rotate" {
+		bot.Send(tgbotapi.NewMessage(chatID, "usage: /icalfeed on|off|rotate"))
+		return
+	}
+	token, err := manageChatToken(ctx, dbstore, tokenKindICal, chatID, mode)
+	var reply string
+	switch {
+	case errors.Is(err, storage.ErrConflict):
+		reply = "Feed is already on\n/icalfeed rotate to get a new link, the old one will stop working"
+	case errors.Is(err, storage.ErrNotFound) && mode == "off":
+		reply = "Feed is already off"
+	case errors.Is(err, storage.ErrNotFound):
+		reply = "Feed is off\n/icalfeed on to enable"
+	case err != nil:
+		logger(ctx).Error("can't change feed", "mode", mode, "err", err)
+		reply = "error: can't change feed, try again later"
+	case mode == "off":
+		reply = "Feed is off, the link does not work anymore"
+	default:
+		reply = fmt.Sprintf("Calendar feed of this chat:\n%s\nAnyone with the link can see timers\n/icalfeed rotate to change it, /icalfeed off to disable", feedURL(token))
+	}
+	bot.Send(tgbotapi.NewMessage(chatID, reply))
+}
+
+// icalFeedHandler serves /ical/<token>.ics with timers of the token's chat
+func icalFeedHandler(dbstore storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		name := strings.TrimPrefix(r.URL.Path, "/ical/")
+		token := strings.TrimSuffix(name, ".ics")
+		if token == name || token == "" || strings.Contains(token, "/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), storageTimeout)
+		defer cancel()
+		chatID, err := dbstore.GetChatByToken(ctx, tokenKindICal, hashToken(token))
+		if errors.Is(err, storage.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			slog.Error("can't resolve feed token", "err", err)
+			http.Error(w, "storage unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		timers, err := dbstore.ListChatTimers(ctx, chatID)
+		if err != nil {
+			slog.Error("can't list feed timers", "chat_id", chatID, "err", err)
+			http.Error(w, "storage unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Header().Set("Cache-Control", "private, max-age=300")
+		if r.Method == http.MethodHead {
+			return
+		}
+		if err = ical.Encode(w, "hafenbot timers", timers); err != nil {
+			slog.Warn("can't write feed", "chat_id", chatID, "err", err)
+		}
+	}
+}
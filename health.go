@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"path"
+	"sync/atomic"
+	"time"
+
+	"github.com/mementor/hafenbot/storage"
+)
+
+// Heartbeats older than these are considered stale
+const (
+	// long polling returns at least every updatesTimeout, followers beat
+	// while waiting for leadership
+	telegramPollMaxAge = 3 * time.Minute
+	// scheduler wakes up at least every 10s
+	schedulerMaxAge = time.Minute
+	// portal is scraped every 30s
+	portalScrapeMaxAge = 5 * time.Minute
+)
+
+// heartbeat records time of last successful iteration of a component
+type heartbeat struct {
+	last atomic.Int64
+}
+
+func (h *heartbeat) Beat() {
+	h.last.Store(time.Now().UnixNano())
+}
+
+func (h *heartbeat) Age() time.Duration {
+	return time.Since(time.Unix(0, h.last.Load()))
+}
+
+var (
+	telegramPollBeat heartbeat
+	schedulerBeat    heartbeat
+	portalScrapeBeat heartbeat
+)
+
+// startHeartbeats gives every component a grace period after start
+func startHeartbeats() {
+	telegramPollBeat.Beat()
+	schedulerBeat.Beat()
+	portalScrapeBeat.Beat()
+}
+
+// pollTracker beats telegramPollBeat on every successful getUpdates request
+type pollTracker struct {
+	next http.RoundTripper
+}
+
+func (t pollTracker) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusOK && path.Base(req.URL.Path) == "getUpdates" {
+		telegramPollBeat.Beat()
+	}
+	return resp, err
+}
+
+type healthCheck struct {
+	OK    bool   `json:"ok"`
+	Age   string `json:"age,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type healthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]healthCheck `json:"checks"`
+}
+
+func beatCheck(h *heartbeat, maxAge time.Duration) healthCheck {
+	age := h.Age()
+	return healthCheck{OK: age <= maxAge, Age: age.Truncate(time.Second).String()}
+}
+
+// healthHandler reports state of bot components. /healthz covers only
+// what restart of the process can fix: stuck scheduler and Telegram polling.
+// /readyz also requires reachable storage and fresh portal status
+func healthHandler(dbstore storage.Storage, ready bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := healthReport{Status: "ok", Checks: map[string]healthCheck{
+			"telegram_poll": beatCheck(&telegramPollBeat, telegramPollMaxAge),
+			"scheduler":     beatCheck(&schedulerBeat, schedulerMaxAge),
+		}}
+		if ready {
+			report.Checks["portal_scrape"] = beatCheck(&portalScrapeBeat, portalScrapeMaxAge)
+			ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+			err := dbstore.Ping(ctx)
+			cancel()
+			report.Checks["storage"] = healthCheck{OK: err == nil}
+			if err != nil {
+				slog.Warn("storage ping failed", "err", err)
+				report.Checks["storage"] = healthCheck{Error: err.Error()}
+			}
+		}
+
+		status := http.StatusOK
+		for _, check := range report.Checks {
+			if !check.OK {
+				report.Status = "fail"
+				status = http.StatusServiceUnavailable
+			}
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			slog.Warn("can't write response", "err", err)
+		}
+	}
+}
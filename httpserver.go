@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mementor/hafenbot/metrics"
+	"github.com/mementor/hafenbot/storage"
+)
+
+// newHTTPMux returns handler with all HTTP endpoints of the bot
+func newHTTPMux(dbstore storage.Storage, reload chan bool) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/ical/", icalFeedHandler(dbstore))
+	mux.Handle(apiPrefix+"/", &apiServer{store: dbstore, reload: reload})
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/healthz", healthHandler(dbstore, false))
+	mux.Handle("/readyz", healthHandler(dbstore, true))
+	return mux
+}
+
+// startHTTP runs HTTP server on addr in background
+func startHTTP(addr string, handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+	go func() {
+		slog.Info("serving HTTP", "addr", addr)
+		err := srv.ListenAndServe()
+		if err != http.ErrServerClosed {
+			slog.Error("HTTP server stopped", "err", err)
+		}
+	}()
+	return srv
+}
+
+// defaultPublicURL guesses base URL of HTTP server listening on addr
+func defaultPublicURL(addr string) string {
+	if strings.HasPrefix(addr, ":") {
+		return "http://localhost" + addr
+	}
+	return "http://" + addr
+}
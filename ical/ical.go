@@ -0,0 +1,191 @@
+// Package ical reads and writes timers as iCalendar (RFC 5545) documents
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/mementor/hafenbot/timer"
+)
+
+const (
+	stampFormat   = "20060102T150405Z"
+	localFormat   = "20060102T150405"
+	dateFormat    = "20060102"
+	maxLineOctets = 75
+)
+
+// Event is a VEVENT found in iCalendar document
+type Event struct {
+	UID     string
+	Start   time.Time
+	Summary string
+}
+
+// Encode writes timers as VCALENDAR with one VEVENT per timer. Timers
+// repeated until done recur every NagEvery. Timers waiting for server
+// state have no time and are left out
+func Encode(w io.Writer, name string, timers []timer.Timer) error {
+	bw := bufio.NewWriter(w)
+	now := time.Now().UTC().Format(stampFormat)
+	writeLine(bw, "BEGIN:VCALENDAR")
+	writeLine(bw, "VERSION:2.0")
+	writeLine(bw, "PRODID:-//hafenbot//timers//EN")
+	writeLine(bw, "CALSCALE:GREGORIAN")
+	if name != "" {
+		writeLine(bw, "X-WR-CALNAME:"+escape(name))
+	}
+	for _, t := range timers {
+		if t.Trigger != "" {
+			continue
+		}
+		writeLine(bw, "BEGIN:VEVENT")
+		writeLine(bw, "UID:"+escape(t.ID)+"@hafenbot")
+		writeLine(bw, "DTSTAMP:"+now)
+		writeLine(bw, "DTSTART:"+t.At.UTC().Format(stampFormat))
+		if rule := recurrence(&t); rule != "" {
+			writeLine(bw, "RRULE:"+rule)
+		}
+		writeLine(bw, "SUMMARY:"+escape(t.Body))
+		writeLine(bw, "END:VEVENT")
+	}
+	writeLine(bw, "END:VCALENDAR")
+	return bw.Flush()
+}
+
+// recurrence returns RRULE of timer repeated after it fires: the timer
+// itself and NagCount repeats. Empty if timer is not repeated
+func recurrence(t *timer.Timer) string {
+	if t.NagCount <= 0 || t.NagEvery <= 0 {
+		return ""
+	}
+	freq, unit := "SECONDLY", time.Second
+	switch {
+	case t.NagEvery%time.Hour == 0:
+		freq, unit = "HOURLY", time.Hour
+	case t.NagEvery%time.Minute == 0:
+		freq, unit = "MINUTELY", time.Minute
+	}
+	return fmt.Sprintf("FREQ=%s;INTERVAL=%d;COUNT=%d", freq, t.NagEvery/unit, t.NagCount+1)
+}
+
+// writeLine writes content line folded to 75 octets as RFC 5545 requires
+func writeLine(w *bufio.Writer, line string) {
+	limit := maxLineOctets
+	for len(line) > limit {
+		cut := limit
+		// do not split multibyte runes
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		w.WriteString(line[:cut])
+		w.WriteString("\r\n ")
+		line = line[cut:]
+		limit = maxLineOctets - 1
+	}
+	w.WriteString(line)
+	w.WriteString("\r\n")
+}
+
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+func unescape(s string) string {
+	return strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n").Replace(s)
+}
+
+// Decode reads VEVENTs from iCalendar document. Times without zone are
+// interpreted in loc. Event with unparsable DTSTART has zero Start
+func Decode(r io.Reader, loc *time.Location) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 || !strings.EqualFold(lines[0], "BEGIN:VCALENDAR") {
+		return nil, fmt.Errorf("not an iCalendar document")
+	}
+
+	var events []Event
+	var ev *Event
+	var description string
+	for _, line := range lines {
+		name, params, value := splitLine(line)
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			ev = &Event{}
+			description = ""
+		case name == "END" && strings.EqualFold(value, "VEVENT") && ev != nil:
+			if ev.Summary == "" {
+				ev.Summary = description
+			}
+			events = append(events, *ev)
+			ev = nil
+		case ev == nil:
+			continue
+		case name == "UID":
+			ev.UID = strings.TrimSuffix(value, "@hafenbot")
+		case name == "SUMMARY":
+			ev.Summary = unescape(value)
+		case name == "DESCRIPTION":
+			description = unescape(value)
+		case name == "DTSTART":
+			ev.Start = parseTime(value, params, loc)
+		}
+	}
+	return events, nil
+}
+
+// unfold joins continuation lines, which start with space or tab
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, sc.Err()
+}
+
+// splitLine splits "NAME;PARAM=x:value" into its parts
+func splitLine(line string) (name string, params map[string]string, value string) {
+	colon := strings.Index(line, ":")
+	if colon < 0 {
+		return strings.ToUpper(line), nil, ""
+	}
+	head := strings.Split(line[:colon], ";")
+	params = make(map[string]string)
+	for _, p := range head[1:] {
+		if kv := strings.SplitN(p, "=", 2); len(kv) == 2 {
+			params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+	return strings.ToUpper(head[0]), params, line[colon+1:]
+}
+
+func parseTime(value string, params map[string]string, loc *time.Location) time.Time {
+	if tzid, ok := params["TZID"]; ok {
+		if tz, err := time.LoadLocation(tzid); err == nil {
+			loc = tz
+		}
+	}
+	if t, err := time.Parse(stampFormat, value); err == nil {
+		return t
+	}
+	if t, err := time.ParseInLocation(localFormat, value, loc); err == nil {
+		return t
+	}
+	if t, err := time.ParseInLocation(dateFormat, value, loc); err == nil {
+		return t
+	}
+	return time.Time{}
+}
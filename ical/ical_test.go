@@ -0,0 +1,142 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mementor/hafenbot/timer"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	at := time.Date(2026, 10, 19, 12, 30, 0, 0, time.UTC)
+	long := strings.Repeat("ж", 60) + "; harvest, then \\ replant\nsecond line"
+	timers := []timer.Timer{
+		{ID: "a1", At: at, Body: "feed pigs"},
+		{ID: "b2", At: at.Add(time.Hour), Body: long},
+		{ID: "c3", At: at, Body: "server is up", Trigger: "up"},
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, "hafenbot timers", timers); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(buf.String(), "\r\n") {
+		if len(line) > maxLineOctets {
+			t.Errorf("line is %d octets long: %q", len(line), line)
+		}
+	}
+
+	events, err := Decode(&buf, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Event{
+		{UID: "a1", Start: at, Summary: "feed pigs"},
+		{UID: "b2", Start: at.Add(time.Hour), Summary: long},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(events), len(want), events)
+	}
+	for i, ev := range events {
+		if ev.UID != want[i].UID || !ev.Start.Equal(want[i].Start) || ev.Summary != want[i].Summary {
+			t.Errorf("event %d: got %+v, want %+v", i, ev, want[i])
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	msk := time.FixedZone("MSK", 3*60*60)
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no zoneinfo:", err)
+	}
+	doc := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"BEGIN:VEVENT",
+		"UID:utc@hafenbot",
+		"DTSTART:20261019T093000Z",
+		"SUMMARY:utc",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:floating",
+		"DTSTART:20261019T093000",
+		"DESCRIPTION:from\\, description",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:zoned",
+		`DTSTART;TZID="Europe/Berlin":20261019T093000`,
+		"SUMMARY:fol",
+		" ded",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:date",
+		"DTSTART;VALUE=DATE:20261019",
+		"SUMMARY:date",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:bad",
+		"DTSTART:tomorrow",
+		"SUMMARY:bad",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n")
+
+	events, err := Decode(strings.NewReader(doc), msk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Event{
+		{UID: "utc", Start: time.Date(2026, 10, 19, 9, 30, 0, 0, time.UTC), Summary: "utc"},
+		{UID: "floating", Start: time.Date(2026, 10, 19, 9, 30, 0, 0, msk), Summary: "from, description"},
+		{UID: "zoned", Start: time.Date(2026, 10, 19, 9, 30, 0, 0, berlin), Summary: "folded"},
+		{UID: "date", Start: time.Date(2026, 10, 19, 0, 0, 0, 0, msk), Summary: "date"},
+		{UID: "bad", Summary: "bad"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(events), len(want), events)
+	}
+	for i, ev := range events {
+		if ev.UID != want[i].UID || !ev.Start.Equal(want[i].Start) || ev.Summary != want[i].Summary {
+			t.Errorf("event %d: got %+v, want %+v", i, ev, want[i])
+		}
+	}
+}
+
+func TestDecodeNotCalendar(t *testing.T) {
+	for _, doc := range []string{"", `{"timers": []}`, "BEGIN:VEVENT\r\nEND:VEVENT"} {
+		if _, err := Decode(strings.NewReader(doc), time.UTC); err == nil {
+			t.Errorf("Decode(%q) succeeded", doc)
+		}
+	}
+}
+
+func TestRecurrence(t *testing.T) {
+	tests := []struct {
+		every time.Duration
+		count int
+		want  string
+	}{
+		{0, 0, ""},
+		{time.Hour, 0, ""},
+		{10 * time.Minute, 3, "FREQ=MINUTELY;INTERVAL=10;COUNT=4"},
+		{2 * time.Hour, 1, "FREQ=HOURLY;INTERVAL=2;COUNT=2"},
+		{90 * time.Second, 5, "FREQ=SECONDLY;INTERVAL=90;COUNT=6"},
+	}
+	for _, tt := range tests {
+		tm := timer.Timer{NagEvery: tt.every, NagCount: tt.count}
+		if got := recurrence(&tm); got != tt.want {
+			t.Errorf("recurrence(%s, %d) = %q, want %q", tt.every, tt.count, got, tt.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	err := Encode(&buf, "", []timer.Timer{{ID: "a1", At: time.Now(), Body: "water", NagEvery: 10 * time.Minute, NagCount: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "\r\nRRULE:FREQ=MINUTELY;INTERVAL=10;COUNT=4\r\n") {
+		t.Errorf("no RRULE in\n%s", buf.String())
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/mementor/hafenbot/metrics"
+	"github.com/mementor/hafenbot/storage"
+)
+
+const (
+	// leaderLeaseName is the name of storage lease held by the leader
+	leaderLeaseName = "leader"
+	// leaderLease is how long the leader stays in charge without renewal.
+	// Failover takes at most that long
+	leaderLease = 30 * time.Second
+	// leaderRenew is how often the lease is renewed or its takeover is tried
+	leaderRenew = 10 * time.Second
+	// updatesTimeout is how long getUpdates long poll waits for updates
+	updatesTimeout = 25 * time.Second
+)
+
+// leadership tracks whether this instance holds the leader lease. Only the
+// leader fires timers, broadcasts status changes and polls Telegram for
+// updates, so several instances can run against the same storage.
+// Telegram serves getUpdates to one client of a bot at a time and answers
+// others with 409 Conflict. A webhook would let every instance take
+// updates, but needs a public HTTPS address the bot doesn't require.
+// Leader polls with updatesTimeout shorter than leaderLease, so the poll of
+// an old leader ends about when a new one takes over. Updates of the last
+// batch not yet confirmed by the old leader may be handled twice
+type leadership struct {
+	store storage.Storage
+	id    string
+	// until is when the held lease expires, in unix nanoseconds
+	until atomic.Int64
+}
+
+func newLeadership(store storage.Storage, id string) *leadership {
+	return &leadership{store: store, id: id}
+}
+
+// defaultInstanceID identifies this process among bot instances
+func defaultInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// IsLeader reports whether the lease is held and not expired yet. When
+// storage is unreachable leadership ends with the lease, as others can
+// take it over by then
+func (l *leadership) IsLeader() bool {
+	return time.Now().UnixNano() < l.until.Load()
+}
+
+// try acquires or renews the lease. reload is notified when leadership is
+// gained so that scheduler picks up timers right away
+func (l *leadership) try(reload chan bool) {
+	was := l.IsLeader()
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+	ok, err := l.store.AcquireLease(ctx, leaderLeaseName, l.id, leaderLease)
+	cancel()
+	switch {
+	case err != nil:
+		slog.Warn("can't renew leader lease", "err", err)
+	case ok:
+		// count from before the call, storage may have written it any time since
+		l.until.Store(start.Add(leaderLease).UnixNano())
+	default:
+		l.until.Store(0)
+	}
+
+	is := l.IsLeader()
+	if is && !was {
+		slog.Info("became leader", "instance", l.id)
+		reload <- true
+	}
+	if was && !is {
+		slog.Warn("lost leadership", "instance", l.id)
+	}
+	if is {
+		metrics.Leader.Set(1)
+	} else {
+		metrics.Leader.Set(0)
+	}
+}
+
+// run keeps the lease till shutdown
+func (l *leadership) run(shutdown context.Context, reload chan bool) {
+	ticker := time.NewTicker(leaderRenew)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.try(reload)
+		case <-shutdown.Done():
+			return
+		}
+	}
+}
+
+// release gives the lease up, so that another instance takes over without
+// waiting for expiration. Call it once scheduler is stopped
+func (l *leadership) release(ctx context.Context) {
+	if !l.IsLeader() {
+		return
+	}
+	l.until.Store(0)
+	metrics.Leader.Set(0)
+	if err := l.store.ReleaseLease(ctx, leaderLeaseName, l.id); err != nil {
+		slog.Warn("can't release leader lease", "err", err)
+		return
+	}
+	slog.Info("leadership released", "instance", l.id)
+}
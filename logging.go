@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+type ctxKey int
+
+const loggerKey ctxKey = 0
+
+// redactedKeys are attributes holding user texts. Only their length is logged
+var redactedKeys = map[string]bool{
+	"body": true,
+	"text": true,
+}
+
+// withLogger returns ctx carrying logger with fields of current update
+func withLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, l)
+}
+
+// logger returns logger of ctx or default one
+func logger(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// setupLogging installs default logger writing to stderr in text or json
+// format. Secrets are masked everywhere including messages and errors
+func setupLogging(format, level string, secrets ...string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("bad log level '%s'", level)
+	}
+	var nonEmpty []string
+	for _, s := range secrets {
+		if s != "" {
+			nonEmpty = append(nonEmpty, s)
+		}
+	}
+	opts := &slog.HandlerOptions{
+		Level:       lvl,
+		ReplaceAttr: redactor{secrets: nonEmpty}.replaceAttr,
+	}
+
+	var handler slog.Handler
+	switch format {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("bad log format '%s'", format)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+type redactor struct {
+	secrets []string
+}
+
+func (r redactor) replaceAttr(groups []string, a slog.Attr) slog.Attr {
+	if redactedKeys[a.Key] && a.Value.Kind() == slog.KindString {
+		return slog.String(a.Key, fmt.Sprintf("[%d chars]", utf8.RuneCountInString(a.Value.String())))
+	}
+	if a.Value.Kind() != slog.KindString && a.Value.Kind() != slog.KindAny {
+		return a
+	}
+	s := a.Value.String()
+	masked := s
+	for _, secret := range r.secrets {
+		masked = strings.ReplaceAll(masked, secret, "[REDACTED]")
+	}
+	if masked != s {
+		return slog.String(a.Key, masked)
+	}
+	return a
+}
+
+// botBodyRes match message texts in debug output of telegram library:
+// JSON responses, url.Values of requests and %+v of tgbotapi.Message.
+// Text is the second submatch, it is replaced by its length
+var botBodyRes = []*regexp.Regexp{
+	regexp.MustCompile(`("(?:text|caption)":")((?:[^"\\]|\\.)*)(")`),
+	regexp.MustCompile(`(\b(?:text|caption):\[)(.*?)(\](?: [a-z_]+:\[|\]))`),
+	regexp.MustCompile(`(\bText:)(.*?)( Entities:)`),
+	regexp.MustCompile(`(\bCaption:)(.*?)( Contact:)`),
+}
+
+// redactBotLine replaces message texts in line logged by telegram library
+func redactBotLine(line string) string {
+	for _, re := range botBodyRes {
+		line = re.ReplaceAllStringFunc(line, func(m string) string {
+			sub := re.FindStringSubmatch(m)
+			return fmt.Sprintf("%s[%d chars]%s", sub[1], utf8.RuneCountInString(sub[2]), sub[3])
+		})
+	}
+	return line
+}
+
+// botLogger routes logs of telegram library through slog. The library logs
+// requests and responses in debug mode and retries of getUpdates, so lines
+// go at debug level with message texts masked
+type botLogger struct{}
+
+func (botLogger) Println(v ...interface{}) {
+	slog.Debug(redactBotLine(strings.TrimSpace(fmt.Sprintln(v...))), "component", "tgbotapi")
+}
+
+func (botLogger) Printf(format string, v ...interface{}) {
+	slog.Debug(redactBotLine(strings.TrimSpace(fmt.Sprintf(format, v...))), "component", "tgbotapi")
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+func TestBotLoggerRedacts(t *testing.T) {
+	const token = "123456:AAH-secret-token"
+	var buf bytes.Buffer
+	prev := slog.Default()
+	defer slog.SetDefault(prev)
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		Level:       slog.LevelDebug,
+		ReplaceAttr: redactor{secrets: []string{token}}.replaceAttr,
+	})))
+
+	var l botLogger
+	l.Println(`Post https://api.telegram.org/bot` + token + `/getUpdates: connection reset`)
+	l.Printf("%s resp: %s", "getUpdates", `{"ok":true,"result":[{"message":{"text":"meet at \"the pier\" at 5"}}]}`)
+	l.Printf("%s req : %+v\n", "sendMessage", map[string][]string{"chat_id": {"1"}, "text": {"feed [all] pigs"}})
+	l.Printf("%s resp: %+v\n", "sendMessage", tgbotapi.Message{MessageID: 7, Text: "feed the pigs"})
+
+	out := buf.String()
+	for _, leaked := range []string{token, "the pier", "feed", "pigs"} {
+		if strings.Contains(out, leaked) {
+			t.Errorf("log contains %q:\n%s", leaked, out)
+		}
+	}
+	for _, want := range []string{"level=DEBUG", "component=tgbotapi", "[REDACTED]", "[25 chars]", "[15 chars]", "[13 chars]", "MessageID:7"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log lacks %q:\n%s", want, out)
+		}
+	}
+}
@@ -2,19 +2,24 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"flag"
 
 	"github.com/PuerkitoBio/goquery"
+	"github.com/mementor/hafenbot/metrics"
 	"github.com/mementor/hafenbot/storage"
 	"github.com/mementor/hafenbot/storage/dynamodb"
 	"github.com/mementor/hafenbot/storage/mongodb"
@@ -27,45 +32,202 @@ const (
 	undone = "undone"
 )
 
+// storageTimeout limits a single round of storage calls
+const storageTimeout = 10 * time.Second
+
+// maxTimerBody is a bit less than Telegram message limit
+const maxTimerBody = 4000
+
+const (
+	portalURL = "http://www.havenandhearth.com/portal/"
+	// portalTimeout limits portal scrape, it runs every 30 seconds
+	portalTimeout = 20 * time.Second
+)
+
+const (
+	// fireLease is how long a claimed timer is left to its scheduler before
+	// delivery is retried
+	fireLease = time.Minute
+	// maxFireAttempts limits delivery retries of a timer
+	maxFireAttempts = 10
+)
+
 // ServerStatus represents current server status
 type ServerStatus struct {
-	Status       string
-	Online       string
-	ChangedState chan string
+	// ChangedState receives previous and current state when status or
+	// online changes
+	ChangedState chan stateChange
+	// Probe checks game server directly, nil if it is disabled
+	Probe *gameProbe
+	// Confirm is number of consecutive scrapes new status has to be seen
+	// in before it becomes current
+	Confirm int
+
+	mu    sync.Mutex
+	state ServerState
+	// candidate is status seen in last scrapes, it is not confirmed yet
+	candidate string
+	seen      int
+}
+
+// stateChange is server state before and after a scrape
+type stateChange struct {
+	Old ServerState
+	New ServerState
+}
+
+// State returns current server state
+func (ss *ServerStatus) State() ServerState {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.state
+}
+
+// observe records status and online seen by a scrape. New status becomes
+// current once it is seen in Confirm consecutive scrapes. Returns the
+// change unless state is the same or it is the first scrape
+func (ss *ServerStatus) observe(status, online string) (stateChange, bool) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	old := ss.state
+	if online == "" {
+		ss.state.Online = "unknown"
+	} else {
+		ss.state.Online = online
+	}
+	if ss.state.Status == status || ss.state.Status == "" {
+		ss.state.Status, ss.candidate, ss.seen = status, "", 0
+	} else {
+		// portal glitches show wrong status for a scrape or two
+		if status != ss.candidate {
+			ss.candidate, ss.seen = status, 0
+		}
+		ss.seen++
+		if ss.seen >= ss.Confirm {
+			slog.Info("server status changed", "old", old.Status, "new", status)
+			ss.state.Status, ss.candidate, ss.seen = status, "", 0
+		} else {
+			slog.Debug("server status not confirmed yet", "status", status, "seen", ss.seen)
+		}
+	}
+	return stateChange{Old: old, New: ss.state}, old.Status != "" && old != ss.state
 }
 
 type button struct {
 	isDone bool
+	// timerID is set on reminders which are repeated until done.
+	// timerChat is chat of the timer, it may be sent to another one
+	timerID   string
+	timerChat int64
 }
 
 var location *time.Location
 
-func checkHealth(ss *ServerStatus) {
-	doc, err := goquery.NewDocument("http://www.havenandhearth.com/portal/")
+// portalClient fetches the portal, a hung request must not stall scrapes
+var portalClient = &http.Client{Timeout: portalTimeout}
+
+// watchPortal scrapes server status from the portal every interval
+// until shutdown. Scrapes never overlap, each is limited by portalTimeout
+func watchPortal(shutdown context.Context, ss *ServerStatus, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if change, ok := checkHealth(shutdown, ss); ok {
+			select {
+			case ss.ChangedState <- change:
+			case <-shutdown.Done():
+				return
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-shutdown.Done():
+			return
+		}
+	}
+}
+
+// pollUpdates long-polls Telegram for updates while this instance is leader
+// and passes them to updates till shutdown. Followers only wait for
+// leadership, see leadership for why
+func pollUpdates(shutdown context.Context, bot *tgbotapi.BotAPI, leader *leadership, updates chan<- tgbotapi.Update) {
+	ucfg := tgbotapi.NewUpdate(0)
+	ucfg.Timeout = int(updatesTimeout / time.Second)
+	for {
+		wait := time.Duration(0)
+		if !leader.IsLeader() {
+			// not polling is the healthy state of a follower
+			telegramPollBeat.Beat()
+			wait = time.Second
+		} else if batch, err := bot.GetUpdates(ucfg); err != nil {
+			slog.Warn("can't get updates, retrying", "err", err)
+			wait = 3 * time.Second
+		} else {
+			for _, update := range batch {
+				if update.UpdateID < ucfg.Offset {
+					continue
+				}
+				ucfg.Offset = update.UpdateID + 1
+				select {
+				case updates <- update:
+				case <-shutdown.Done():
+					return
+				}
+			}
+		}
+		select {
+		case <-time.After(wait):
+		case <-shutdown.Done():
+			return
+		}
+	}
+}
+
+// checkHealth scrapes the portal once and records server state
+func checkHealth(ctx context.Context, ss *ServerStatus) (stateChange, bool) {
+	start := time.Now()
+	doc, err := fetchPortal(ctx)
 	if err != nil {
-		log.Println(err)
-		return
+		metrics.ObserveScrape(start, err)
+		slog.Warn("portal scrape failed", "err", err)
+		return stateChange{}, false
 	}
+	var change stateChange
+	changed := false
+	found := false
 	doc.Find(".vertdiv").Eq(1).Each(func(i int, s *goquery.Selection) {
 		status := s.Find("h2").Text()
 		online := s.Find("p").Eq(0).Text()
 		if status != "" {
-			if online == "" {
-				ss.Online = "unknown"
-			} else {
-				ss.Online = online
-			}
-			if ss.Status != status {
-				oldStatus := ss.Status
-				ss.Status = status
-				if oldStatus != "" {
-					log.Println("Status changed")
-					ss.ChangedState <- oldStatus
-				}
-			}
+			found = true
+			change, changed = ss.observe(status, online)
 		}
 	})
-	return
+	if !found {
+		err = errors.New("no server status on portal page")
+		slog.Warn("portal scrape failed", "err", err)
+	} else {
+		portalScrapeBeat.Beat()
+	}
+	metrics.ObserveScrape(start, err)
+	return change, changed
+}
+
+// fetchPortal downloads and parses the portal page
+func fetchPortal(ctx context.Context) (*goquery.Document, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, portalURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := portalClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("portal: %s", resp.Status)
+	}
+	return goquery.NewDocumentFromReader(resp.Body)
 }
 
 func getInlineKeyboard(btn button) (keyboard *tgbotapi.InlineKeyboardMarkup) {
@@ -75,6 +237,9 @@ func getInlineKeyboard(btn button) (keyboard *tgbotapi.InlineKeyboardMarkup) {
 		text = "✓"
 		data = done
 	}
+	if btn.timerID != "" {
+		data += fmt.Sprintf(":%s:%d", btn.timerID, btn.timerChat)
+	}
 	keyboard = &tgbotapi.InlineKeyboardMarkup{
 		InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{
 			[]tgbotapi.InlineKeyboardButton{
@@ -88,51 +253,122 @@ func getInlineKeyboard(btn button) (keyboard *tgbotapi.InlineKeyboardMarkup) {
 	return
 }
 
-func forTheWatch(store storage.Storage, bot *tgbotapi.BotAPI, reload chan bool) {
-	defer log.Println("John Snow died...")
-	log.Println("Its my watch")
-	var reply string
+// forTheWatch fires timers until shutdown is done. Timer being fired
+// when shutdown happens is finished first
+func forTheWatch(shutdown context.Context, store storage.Storage, bot *tgbotapi.BotAPI, leader *leadership, reload chan bool) {
+	slog.Info("scheduler started")
 	var wg sync.WaitGroup
 	reload <- true
 	ticker := time.Tick(10 * time.Second)
 	nearestID := ""
+	var nearestDue time.Time
 	for {
 		select {
 		case <-reload:
+			if !leader.IsLeader() {
+				// timer has to be scheduled again once leadership is back
+				nearestID = ""
+				schedulerBeat.Beat()
+				break
+			}
 			wg.Wait()
 			wg.Add(1)
-			timer, err := store.GetNearestTimer()
+			ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+			timer, err := store.GetNearestTimer(ctx)
 			if err != nil {
-				log.Println(err)
+				slog.Error("can't get nearest timer", "err", err)
 			} else if timer != nil {
-				if timer.At.Before(time.Now()) {
-					err = store.DeleteTimer(timer.ChatID, timer.ID)
-					if err != nil {
-						log.Println(err)
-					} else {
-						reply = fmt.Sprintf("⏰ %s\n%s", timer.At.In(location).Format("2006-01-02 15:04:05 MST"), timer.Body)
-						msg := tgbotapi.NewMessage(int64(timer.ChatID), reply)
-						msg.BaseChat.ReplyMarkup = getInlineKeyboard(button{isDone: false})
-						bot.Send(msg)
+				if timer.Due.Before(time.Now()) {
+					if fireTimer(ctx, store, bot, timer) {
 						reload <- true
 					}
 				} else {
-					if timer.ID != nearestID {
-						log.Printf("sleep for %s", timer.At.Sub(time.Now()))
+					if timer.ID != nearestID || !timer.Due.Equal(nearestDue) {
+						slog.Debug("sleeping until next timer", "timer_id", timer.ID, "sleep", time.Until(timer.Due))
 						nearestID = timer.ID
-						time.AfterFunc(timer.At.Sub(time.Now()), func() {
+						nearestDue = timer.Due
+						time.AfterFunc(time.Until(timer.Due), func() {
 							reload <- true
 						})
 					}
 				}
 			}
+			cancel()
 			wg.Done()
+			schedulerBeat.Beat()
 		case <-ticker:
 			reload <- true
+		case <-shutdown.Done():
+			slog.Info("scheduler stopped")
+			return
 		}
 	}
 }
 
+// fireTimer delivers due timer at least once. Timer is claimed for fireLease,
+// sent and only then deleted, so if the bot dies or Telegram fails in between
+// the timer is sent again after the lease expires. Returns false if timer
+// could not be claimed because of storage failure
+func fireTimer(ctx context.Context, store storage.Storage, bot *tgbotapi.BotAPI, timer *timer.Timer) bool {
+	lg := slog.With("chat_id", timer.ChatID, "timer_id", timer.ID)
+	if timer.Attempts >= maxFireAttempts {
+		lg.Error("giving up on timer delivery", "attempts", timer.Attempts)
+		if err := store.DeleteTimer(ctx, timer.ChatID, timer.ID); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			lg.Error("can't delete undeliverable timer", "err", err)
+			return false
+		}
+		return true
+	}
+	err := store.ClaimTimer(ctx, timer, time.Now().Add(fireLease))
+	if errors.Is(err, storage.ErrConflict) {
+		lg.Debug("timer is already claimed")
+		return true
+	}
+	if err != nil {
+		lg.Error("can't claim timer", "err", err)
+		return false
+	}
+	if len(timer.PendingWarnings()) > 0 && timer.At.After(time.Now()) {
+		warnTimer(ctx, store, bot, timer, lg)
+		return true
+	}
+	if timer.Fired {
+		nagTimer(ctx, store, bot, timer, lg)
+		return true
+	}
+
+	reply := fmt.Sprintf("⏰ %s\n%s", timer.At.In(location).Format("2006-01-02 15:04:05 MST"), timer.Body)
+	msg := tgbotapi.NewMessage(timer.ChatID, reply)
+	btn := button{isDone: false}
+	if timer.NagCount > 0 {
+		btn.timerID = timer.ID
+		btn.timerChat = timer.ChatID
+	}
+	msg.BaseChat.ReplyMarkup = getInlineKeyboard(btn)
+	addMentions(&msg, timer, false)
+	if err = sendToTarget(bot, timer, msg, lg); err != nil {
+		lg.Warn("can't send fired timer, will retry", "attempt", timer.Attempts, "retry_at", timer.Due, "err", err)
+		return true
+	}
+	lg.Info("timer fired", "lateness", time.Since(timer.At), "attempt", timer.Attempts)
+	metrics.TimersFired.Inc()
+	metrics.FireLateness.Observe(time.Since(timer.At).Seconds())
+
+	if timer.NagCount > 0 {
+		// keep timer for repeats until someone marks it done
+		timer.Fired = true
+		if err = store.RescheduleTimer(ctx, timer, time.Now().Add(timer.NagEvery)); err != nil {
+			lg.Error("can't schedule repeats of fired timer, it may be sent again", "err", err)
+		}
+		return true
+	}
+	err = store.DeleteTimer(ctx, timer.ChatID, timer.ID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		lg.Error("can't delete delivered timer, it will be sent again", "err", err)
+	}
+	return true
+}
+
 func parseDuration(str string) (dur time.Duration, err error) {
 	var overall int
 	reWeeks := regexp.MustCompile("^(\\d+(.\\d+)?)w$")
@@ -142,42 +378,35 @@ func parseDuration(str string) (dur time.Duration, err error) {
 	reSeconds := regexp.MustCompile("^(\\d+(.\\d+)?)s$")
 
 	str = regexp.MustCompile("([wdhms])(\\d)").ReplaceAllString(str, "$1,$2")
-	fmt.Printf("replaced: %s\n", str)
 	tockens := strings.Split(str, ",")
-	fmt.Printf("tockens: %s\n", tockens)
 	for _, to := range tockens {
 		if reWeeks.MatchString(to) {
 			weeks, err1 := strconv.ParseFloat(reWeeks.ReplaceAllString(to, "$1"), 10)
 			if err1 != nil {
-				fmt.Printf("err: %s", err)
 				return dur, err1
 			}
 			overall += int(weeks * 7 * 24 * 60 * 60)
 		} else if reDays.MatchString(to) {
 			days, err1 := strconv.ParseFloat(reDays.ReplaceAllString(to, "$1"), 10)
 			if err1 != nil {
-				fmt.Printf("err: %s", err)
 				return dur, err1
 			}
 			overall += int(days * 24 * 60 * 60)
 		} else if reHours.MatchString(to) {
 			hours, err1 := strconv.ParseFloat(reHours.ReplaceAllString(to, "$1"), 10)
 			if err1 != nil {
-				fmt.Printf("err: %s", err)
 				return dur, err1
 			}
 			overall += int(hours * 60 * 60)
 		} else if reMinutes.MatchString(to) {
 			minutes, err1 := strconv.ParseFloat(reMinutes.ReplaceAllString(to, "$1"), 10)
 			if err1 != nil {
-				fmt.Printf("err: %s", err)
 				return dur, err1
 			}
 			overall += int(minutes * 60)
 		} else if reSeconds.MatchString(to) {
 			seconds, err1 := strconv.ParseFloat(reSeconds.ReplaceAllString(to, "$1"), 10)
 			if err1 != nil {
-				log.Printf("err: %s", err)
 				return dur, err1
 			}
 			overall += int(seconds)
@@ -190,9 +419,57 @@ func parseDuration(str string) (dur time.Duration, err error) {
 	return
 }
 
+// cutFlag removes "<name> <value>" from command words
+func cutFlag(words []string, name string) (rest []string, value string, found bool) {
+	for i, w := range words {
+		if w != name {
+			continue
+		}
+		rest = append(rest, words[:i]...)
+		if i+1 < len(words) {
+			value = words[i+1]
+			rest = append(rest, words[i+2:]...)
+		}
+		return rest, value, true
+	}
+	return words, "", false
+}
+
+// cutSwitch removes name from command words and reports if it was there
+func cutSwitch(words []string, name string) ([]string, bool) {
+	for i, w := range words {
+		if w == name {
+			return append(words[:i:i], words[i+1:]...), true
+		}
+	}
+	return words, false
+}
+
+// catalogData returns contents of file or builtin data if file is not set
+func catalogData(file string, builtin []byte) ([]byte, error) {
+	if file == "" {
+		return builtin, nil
+	}
+	return os.ReadFile(file)
+}
+
+// validateTimer checks timer created from outside of /timer command
+func validateTimer(at time.Time, body string, now time.Time) error {
+	switch {
+	case at.IsZero():
+		return errors.New("no valid time")
+	case at.Before(now):
+		return errors.New("time is in past")
+	case body == "":
+		return errors.New("timer have no text")
+	case len(body) > maxTimerBody:
+		return errors.New("text is too long")
+	}
+	return nil
+}
+
 func parseDateTime(str string) (t time.Time, err error) {
 	// 2006-01-02 15:04:05 MST
-	fmt.Printf("parseDateTime\n")
 	fullFormats := []string{"20060102 15:04", "20060102 15:04:05", "02.01.2006 15:04", "02.01.2006 15:04:05"}
 	monthFormats := []string{"02.01 15:04", "0201 15:04"}
 	dayFormats := []string{"02 15:04"}
@@ -200,10 +477,8 @@ func parseDateTime(str string) (t time.Time, err error) {
 	for _, format := range fullFormats {
 		t, err := time.ParseInLocation(format, str, location)
 		if err == nil {
-			fmt.Printf("parsed time: %s\n", t)
 			return t, nil
 		}
-		fmt.Printf("err1: %s\n", err)
 	}
 	for _, format := range timeFormats {
 		tim, err := time.ParseInLocation(format, str, location)
@@ -241,216 +516,616 @@ func parseDateTime(str string) (t time.Time, err error) {
 	return time.Time{}, errors.New("Cant parse datetime")
 }
 
+func handleUpdate(ctx context.Context, bot *tgbotapi.BotAPI, dbstore storage.Storage, ss *ServerStatus, reload chan bool, update tgbotapi.Update) {
+	lg := logger(ctx).With("update_id", update.UpdateID)
+	if update.CallbackQuery != nil {
+		metrics.Updates.WithLabelValues("callback").Inc()
+		lg.Debug("callback received", "chat_id", update.CallbackQuery.Message.Chat.ID, "user_id", update.CallbackQuery.From.ID, "data", update.CallbackQuery.Data)
+		state, timerRef, _ := strings.Cut(update.CallbackQuery.Data, ":")
+		timerID, timerChatStr, _ := strings.Cut(timerRef, ":")
+		// buttons made before delivery targets carry no timer chat
+		timerChat := update.CallbackQuery.Message.Chat.ID
+		if c, err := strconv.ParseInt(timerChatStr, 10, 64); err == nil {
+			timerChat = c
+		}
+		buttonData := done
+		buttonText := "✓"
+		newMsgText := strings.Replace(update.CallbackQuery.Message.Text, "⏰", "✓", 1)
+		if state == done {
+			buttonData = undone
+			buttonText = "✗"
+			newMsgText = strings.Replace(update.CallbackQuery.Message.Text, "✓", "⏰", 1)
+		} else if timerID != "" {
+			stopNagging(ctx, dbstore, timerChat, timerID)
+		}
+		if timerID != "" {
+			buttonData += fmt.Sprintf(":%s:%d", timerID, timerChat)
+		}
+		markup := tgbotapi.InlineKeyboardMarkup{
+			InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{
+				[]tgbotapi.InlineKeyboardButton{
+					tgbotapi.InlineKeyboardButton{
+						Text:         buttonText,
+						CallbackData: &buttonData,
+					},
+				},
+			},
+		}
+		editConfig := tgbotapi.EditMessageTextConfig{
+			BaseEdit: tgbotapi.BaseEdit{
+				ChatID:      update.CallbackQuery.Message.Chat.ID,
+				MessageID:   update.CallbackQuery.Message.MessageID,
+				ReplyMarkup: &markup,
+			},
+			Text: newMsgText,
+		}
+		if _, err := bot.Send(editConfig); err != nil {
+			lg.Warn("can't update fired timer", "err", err)
+		}
+	}
+	if update.Message == nil {
+		return
+	}
+	UserName := update.Message.From.UserName
+	UserID := update.Message.From.ID
+	ChatID := update.Message.Chat.ID
+	text := update.Message.Text
+	if text == "" {
+		// documents carry command in caption
+		text = update.Message.Caption
+	}
+	strs := strings.Split(text, " ")
+	command := strings.Split(strings.ToLower(strs[0]), "@")[0]
+	body := strings.Join(strs[1:], " ")
+
+	if command == "" {
+		return
+	}
+	lg = lg.With("chat_id", ChatID, "user_id", UserID, "command", command)
+	ctx = withLogger(ctx, lg)
+	metricCommand := command
+	defer func() { metrics.Updates.WithLabelValues(metricCommand).Inc() }()
+
+	if command == "/status" {
+		state := ss.State()
+		reply := fmt.Sprintf("Status: %s\nVerdict: %s", state.Status, ss.Probe.verdict(state.Status))
+		msg := tgbotapi.NewMessage(ChatID, reply)
+		bot.Send(msg)
+	} else if command == "/online" {
+		reply := fmt.Sprintf("Online: %s", ss.State().Online)
+		msg := tgbotapi.NewMessage(ChatID, reply)
+		bot.Send(msg)
+	} else if command == "/statuson" {
+		err := dbstore.AppendToSSList(ctx, ChatID)
+		var reply string
+		if err == nil {
+			reply = "Now you will receive server statuses on server change\n/statusoff to disable"
+		} else if errors.Is(err, storage.ErrAlreadySubscribed) {
+			reply = "You are already subscribed\n/statusoff to disable"
+		} else {
+			lg.Error("can't subscribe", "err", err)
+			reply = "Error: can't subscribe, try again later"
+		}
+		msg := tgbotapi.NewMessage(ChatID, reply)
+		bot.Send(msg)
+	} else if command == "/statusoff" {
+		err := dbstore.DeleteFromSSList(ctx, ChatID)
+		reply := "Now you will NOT receive server statuses on server change\n/statuson to enable"
+		if errors.Is(err, storage.ErrNotFound) {
+			reply = "You are not subscribed\n/statuson to enable"
+		} else if err != nil {
+			lg.Error("can't unsubscribe", "err", err)
+			reply = "Error: can't unsubscribe, try again later"
+		}
+		msg := tgbotapi.NewMessage(ChatID, reply)
+		bot.Send(msg)
+	} else if command == "/newson" {
+		err := dbstore.AppendToNewsList(ctx, ChatID)
+		reply := "Now you will receive game news\n/newsoff to disable"
+		if errors.Is(err, storage.ErrAlreadySubscribed) {
+			reply = "You are already subscribed\n/newsoff to disable"
+		} else if err != nil {
+			lg.Error("can't subscribe to news", "err", err)
+			reply = "Error: can't subscribe, try again later"
+		}
+		bot.Send(tgbotapi.NewMessage(ChatID, reply))
+	} else if command == "/newsoff" {
+		err := dbstore.DeleteFromNewsList(ctx, ChatID)
+		reply := "Now you will NOT receive game news\n/newson to enable"
+		if errors.Is(err, storage.ErrNotFound) {
+			reply = "You are not subscribed\n/newson to enable"
+		} else if err != nil {
+			lg.Error("can't unsubscribe from news", "err", err)
+			reply = "Error: can't unsubscribe, try again later"
+		}
+		bot.Send(tgbotapi.NewMessage(ChatID, reply))
+	} else if command == "/clienton" {
+		err := dbstore.AppendToClientList(ctx, ChatID)
+		reply := "Now you will receive game client updates\n/clientoff to disable"
+		if errors.Is(err, storage.ErrAlreadySubscribed) {
+			reply = "You are already subscribed\n/clientoff to disable"
+		} else if err != nil {
+			lg.Error("can't subscribe to client updates", "err", err)
+			reply = "Error: can't subscribe, try again later"
+		}
+		bot.Send(tgbotapi.NewMessage(ChatID, reply))
+	} else if command == "/clientoff" {
+		err := dbstore.DeleteFromClientList(ctx, ChatID)
+		reply := "Now you will NOT receive game client updates\n/clienton to enable"
+		if errors.Is(err, storage.ErrNotFound) {
+			reply = "You are not subscribed\n/clienton to enable"
+		} else if err != nil {
+			lg.Error("can't unsubscribe from client updates", "err", err)
+			reply = "Error: can't unsubscribe, try again later"
+		}
+		bot.Send(tgbotapi.NewMessage(ChatID, reply))
+	} else if command == "/timezone" {
+		// anyone may look, only admins change
+		if strings.TrimSpace(body) == "" || requireAdmin(ctx, bot, update.Message) {
+			handleTimeZone(ctx, bot, dbstore, ChatID, body)
+		}
+	} else if command == "/statusformat" {
+		if strings.TrimSpace(body) == "" || requireAdmin(ctx, bot, update.Message) {
+			handleStatusFormat(ctx, bot, dbstore, ss, ChatID, body)
+		}
+	} else if command == "/onlinealert" {
+		handleOnlineAlert(ctx, bot, dbstore, ChatID, body)
+	} else if command == "/timer" || command == "/timerme" { // dirty shit
+		strs, warnArg, withWarn := cutFlag(strs, "--warn")
+		strs, nagArg, withNag := cutFlag(strs, "--nag")
+		strs, nagMention := cutSwitch(strs, "--mention")
+		if len(strs) < 3 {
+			bot.Send(tgbotapi.NewMessage(ChatID, "send me timer in following format:\n /timer text 15m\n /timerme text 15m (sent to you privately)\n /timer text 2h --warn 30m,5m\n /timer text 15m --nag 10mx3 --mention"))
+			return
+		}
+		var warnings []time.Duration
+		if withWarn {
+			var err error
+			if warnings, err = parseWarnings(warnArg); err != nil {
+				bot.Send(tgbotapi.NewMessage(ChatID, fmt.Sprintf("error: %s", err)))
+				return
+			}
+		}
+		var nagEvery time.Duration
+		var nagCount int
+		if withNag {
+			var err error
+			if nagEvery, nagCount, err = parseNag(nagArg); err != nil {
+				bot.Send(tgbotapi.NewMessage(ChatID, fmt.Sprintf("error: %s", err)))
+				return
+			}
+		}
+		description := strings.Join(strs[1:len(strs)-1], " ")
+		delayTwoWords := strings.Join(strs[len(strs)-2:], " ")
+		delay := strs[len(strs)-1]
+		reply := ""
+		ok := false
+		duration, err := parseDuration(delay)
+		var fireAt time.Time
+		if err != nil {
+			fireAt, err = parseDateTime(delayTwoWords)
+			description = strings.Join(strs[1:len(strs)-2], " ")
+			if err != nil {
+				fireAt, err = parseDateTime(delay)
+				description = strings.Join(strs[1:len(strs)-1], " ")
+				if err != nil {
+					reply = fmt.Sprintf("error: '%s'\n", err)
+				}
+			}
+			if fireAt.Before(time.Now()) {
+				reply = fmt.Sprintf("error: time is in past")
+			} else {
+				ok = true
+			}
+		} else {
+			fireAt = time.Now().Add(duration)
+			ok = true
+		}
+
+		if ok {
+			if description == "" {
+				reply = "error: timer have no text"
+			} else {
+				timer := &timer.Timer{
+					At:       fireAt,
+					Body:     description,
+					ChatID:   ChatID,
+					Warnings: warnings,
+					// creator is mentioned by nags
+					CreatorID:   UserID,
+					CreatorName: userName(update.Message.From),
+					NagEvery:    nagEvery,
+					NagCount:    nagCount,
+					NagMention:  nagMention,
+					Assignees:   parseAssignees(update.Message, description, bot.Self.UserName),
+				}
+				// private chat with user has the same ID as the user
+				if command == "/timerme" && ChatID != int64(UserID) {
+					timer.DeliverTo = int64(UserID)
+				}
+				timer.ResetWarnings(time.Now())
+				err = dbstore.SaveTimer(ctx, timer)
+				if err != nil {
+					lg.Error("can't save timer", "err", err)
+					reply = "error: can't save timer, try again later"
+				} else {
+					lg.Info("timer created", "timer_id", timer.ID, "at", fireAt)
+					reply = fmt.Sprintf("⏲ fire at %s", fireAt.In(location).Format("2006-01-02 15:04:05 MST"))
+					if pending := timer.PendingWarnings(); len(pending) > 0 {
+						reply += fmt.Sprintf("\n⏳ warn %s before", formatWarnings(pending))
+					}
+					if timer.Warned > 0 {
+						reply += fmt.Sprintf("\nskipped warnings which are already late: %s", formatWarnings(timer.Warnings[:timer.Warned]))
+					}
+					if len(timer.Assignees) > 0 {
+						reply += fmt.Sprintf("\n👤 assigned to %s", formatAssignees(timer.Assignees))
+					}
+					if timer.DeliverTo != 0 {
+						reply += fmt.Sprintf("\n📬 will be sent to %s privately, start a chat with @%s if you haven't", timer.CreatorName, bot.Self.UserName)
+					}
+					if timer.NagCount > 0 {
+						reply += fmt.Sprintf("\n🔁 repeat every %s up to %d times until done", formatDuration(timer.NagEvery), timer.NagCount)
+					}
+					metrics.TimersCreated.WithLabelValues("command").Inc()
+					reload <- true
+				}
+			}
+		}
+		bot.Send(tgbotapi.NewMessage(ChatID, reply))
+	} else if command == "/timerlist" {
+		timers, err := dbstore.ListChatTimers(ctx, ChatID)
+		var reply bytes.Buffer
+		if err != nil {
+			lg.Error("can't list timers", "err", err)
+			reply.WriteString("error: can't list timers, try again later")
+		} else if len(timers) == 0 {
+			reply.WriteString("No timers here yet")
+		} else {
+			for _, t := range timers {
+				if t.Trigger != "" {
+					reply.WriteString(fmt.Sprintf("⚡ %s\n", describeTrigger(t.Trigger)))
+				} else {
+					reply.WriteString(fmt.Sprintf("⏲ %s\n", t.At.In(location).Format("2006-01-02 15:04:05 MST")))
+				}
+				if t.TriggerEvery {
+					reply.WriteString("🔁 every time\n")
+				}
+				if pending := t.PendingWarnings(); len(pending) > 0 {
+					reply.WriteString(fmt.Sprintf("⏳ warn %s before\n", formatWarnings(pending)))
+				}
+				if len(t.Assignees) > 0 {
+					reply.WriteString(fmt.Sprintf("👤 %s\n", formatAssignees(t.Assignees)))
+				}
+				if t.DeliverTo != 0 {
+					reply.WriteString(fmt.Sprintf("📬 sent to %s privately\n", t.CreatorName))
+				}
+				if t.Fired {
+					reply.WriteString(fmt.Sprintf("🔁 fired, repeating every %s until done, %d left\n", formatDuration(t.NagEvery), t.NagCount-t.Nagged))
+				} else if t.NagCount > 0 {
+					reply.WriteString(fmt.Sprintf("🔁 repeat every %s up to %d times until done\n", formatDuration(t.NagEvery), t.NagCount))
+				}
+				reply.WriteString(fmt.Sprintf("%s\n%s\n\n", t.Body, t.ID))
+			}
+		}
+		bot.Send(tgbotapi.NewMessage(ChatID, reply.String()))
+	} else if command == "/timerdel" {
+		err := dbstore.DeleteTimer(ctx, ChatID, body)
+		if errors.Is(err, storage.ErrNotFound) {
+			bot.Send(tgbotapi.NewMessage(ChatID, "No such timer"))
+		} else if err != nil {
+			lg.Error("can't delete timer", "timer_id", body, "err", err)
+			bot.Send(tgbotapi.NewMessage(ChatID, "error: can't delete timer, try again later"))
+		} else {
+			lg.Info("timer deleted", "timer_id", body)
+			metrics.TimersDeleted.WithLabelValues("command").Inc()
+			bot.Send(tgbotapi.NewMessage(ChatID, "Done!"))
+		}
+	} else if command == "/when" {
+		handleWhen(ctx, bot, dbstore, ss, update.Message, body)
+	} else if command == "/preset" || command == "/p" {
+		handlePreset(ctx, bot, dbstore, reload, update.Message, body)
+	} else if command == "/presets" {
+		handlePresets(bot, ChatID)
+	} else if command == "/curio" {
+		handleCurio(ctx, bot, dbstore, reload, update.Message, body)
+	} else if command == "/crop" {
+		handleCrop(ctx, bot, dbstore, reload, update.Message, body)
+	} else if command == "/crops" {
+		handleCrops(ctx, bot, dbstore, ChatID)
+	} else if command == "/cropdel" {
+		handleCropDel(ctx, bot, dbstore, reload, ChatID, body)
+	} else if command == "/mytimers" {
+		handleMyTimers(ctx, bot, dbstore, update.Message)
+	} else if command == "/apikey" {
+		if requireAdmin(ctx, bot, update.Message) {
+			handleAPIKey(ctx, bot, dbstore, ChatID, body)
+		}
+	} else if command == "/icalfeed" {
+		if requireAdmin(ctx, bot, update.Message) {
+			handleICalFeed(ctx, bot, dbstore, ChatID, body)
+		}
+	} else if command == "/export" {
+		handleExport(ctx, bot, dbstore, ChatID)
+	} else if command == "/import" {
+		handleImport(ctx, bot, dbstore, reload, update.Message)
+	} else {
+		metricCommand = "unknown"
+		reply := fmt.Sprintf("Unknown command: '%s'", command)
+		msg := tgbotapi.NewMessage(ChatID, reply)
+		bot.Send(msg)
+	}
+	lg.Info("command handled", "user", UserName, "text", text)
+}
+
+// openStorage connects to storage by driver name
+func openStorage(dbdriver, mongosrv string) (storage.Storage, error) {
+	var dbstore storage.Storage
+	var err error
+	switch dbdriver {
+	case "mongo":
+		dbstore, err = mongodb.GetMongoStore(mongosrv)
+	case "dynamo":
+		dbstore, err = dynamodb.GetDynamoStore()
+	default:
+		return nil, fmt.Errorf("No such dbdriver: '%s'", dbdriver)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return metrics.Storage(dbdriver, dbstore), nil
+}
+
 func main() {
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(migrate(os.Args[2:]))
+	}
 	var mongosrv string
 	var botToken string
 	var dbdriver string
 	var debug bool
+	var httpAddr string
+	var logFormat string
+	var logLevel string
+	var shutdownTimeout time.Duration
+	var instanceID string
+	var presetsFile string
+	var curiosFile string
+	var cropsFile string
+	var statusConfirm int
+	var gameAddr string
+	var newsURL string
+	var newsSelector string
+	var newsInterval time.Duration
+	var clientURL string
+	var clientVersion string
+	var clientInterval time.Duration
 	flag.StringVar(&botToken, "token", "", "Token to the bot")
 	flag.StringVar(&dbdriver, "dbdriver", "", "Database driver to use (mongo or dynamo)")
 	flag.StringVar(&mongosrv, "mongosrv", "", "Address of mongo servers")
-	flag.BoolVar(&debug, "debug", false, "Debug to stdout")
+	flag.BoolVar(&debug, "debug", false, "Log debug messages and dump Telegram traffic")
+	flag.StringVar(&logFormat, "log-format", "text", "Log format (text or json)")
+	flag.StringVar(&logLevel, "log-level", "info", "Minimal log level (debug, info, warn or error)")
+	flag.StringVar(&httpAddr, "http", "", "Address to serve HTTP on, e.g. :8080 (disabled if empty)")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 20*time.Second, "How long to wait for in-flight work on SIGINT/SIGTERM")
+	flag.StringVar(&instanceID, "instance-id", defaultInstanceID(), "Name of this instance in leader election")
+	flag.StringVar(&presetsFile, "presets", "", "YAML file with timer presets (default built-in catalog)")
+	flag.StringVar(&curiosFile, "curios", "", "YAML file with curiosities (default built-in database)")
+	flag.StringVar(&cropsFile, "crops", "", "YAML file with crop growth stages (default built-in data)")
+	flag.IntVar(&statusConfirm, "status-confirm", 2, "Number of consecutive portal scrapes server status has to hold before it is reported")
+	flag.StringVar(&gameAddr, "game-addr", "", "Game server host:port to probe with TCP connects alongside the portal (disabled if empty)")
+	flag.StringVar(&newsURL, "news-url", "", "RSS, Atom or HTML page with game news posted to /newson chats (disabled if empty)")
+	flag.StringVar(&newsSelector, "news-selector", "", "CSS selector of news items if news-url is an HTML page")
+	flag.DurationVar(&newsInterval, "news-interval", 10*time.Minute, "How often news-url is checked")
+	flag.StringVar(&clientURL, "client-url", "", "Game client version file or jar whose changes are posted to /clienton chats (disabled if empty)")
+	flag.StringVar(&clientVersion, "client-version", "", "Regexp of version in client-url, its first group if any (default hash of the whole file)")
+	flag.DurationVar(&clientInterval, "client-interval", 15*time.Minute, "How often client-url is checked")
+	flag.StringVar(&publicURL, "public-url", "", "Base URL of HTTP server for links sent to chats (default http://localhost<port>)")
 
 	flag.Parse()
 
-	var dbstore storage.Storage
-	var err error
+	if debug {
+		logLevel = "debug"
+	}
+	if err := setupLogging(logFormat, logLevel, botToken); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	tgbotapi.SetLogger(botLogger{})
 
-	if dbdriver == "mongo" {
-		dbstore, err = mongodb.GetMongoStore(mongosrv)
-		if err != nil {
-			log.Print(err)
-			os.Exit(1)
-		}
-	} else if dbdriver == "dynamo" {
-		dbstore, err = dynamodb.GetDynamoStore()
-		if err != nil {
-			log.Print(err)
-			os.Exit(1)
-		}
-	} else {
-		log.Fatal("No such --dbdriver")
+	dbstore, err := openStorage(dbdriver, mongosrv)
+	if err != nil {
+		slog.Error("can't open storage", "driver", dbdriver, "err", err)
+		os.Exit(1)
 	}
 
 	location = time.FixedZone("MSK", 3*60*60)
 
-	ss := &ServerStatus{}
-	ss.ChangedState = make(chan string)
-	bot, err := tgbotapi.NewBotAPI(botToken)
+	presetsData, err := catalogData(presetsFile, defaultPresets)
+	if err == nil {
+		presets, err = loadPresets(presetsData)
+	}
 	if err != nil {
-		log.Panic(err)
+		slog.Error("can't load presets", "file", presetsFile, "err", err)
+		os.Exit(1)
+	}
+	curiosData, err := catalogData(curiosFile, defaultCurios)
+	if err == nil {
+		curios, err = loadCurios(curiosData)
 	}
-	bot.Debug = debug
-	log.Printf("Authorized on account %s", bot.Self.UserName)
-	ucfg := tgbotapi.NewUpdate(0)
-	ucfg.Timeout = 60
-	updates, err := bot.GetUpdatesChan(ucfg)
 	if err != nil {
-		log.Panic(err)
-		return
+		slog.Error("can't load curios", "file", curiosFile, "err", err)
+		os.Exit(1)
+	}
+	cropsData, err := catalogData(cropsFile, defaultCrops)
+	if err == nil {
+		crops, err = loadCrops(cropsData)
+	}
+	if err != nil {
+		slog.Error("can't load crops", "file", cropsFile, "err", err)
+		os.Exit(1)
+	}
+
+	if statusConfirm < 1 {
+		slog.Error("status-confirm must be at least 1", "value", statusConfirm)
+		os.Exit(2)
+	}
+	var clientVersionRe *regexp.Regexp
+	if clientVersion != "" {
+		if clientVersionRe, err = regexp.Compile(clientVersion); err != nil {
+			slog.Error("bad client-version regexp", "err", err)
+			os.Exit(2)
+		}
+	}
+	ss := &ServerStatus{Confirm: statusConfirm, Probe: newGameProbe(gameAddr)}
+	ss.ChangedState = make(chan stateChange)
+	startHeartbeats()
+	client := metrics.TelegramClient()
+	client.Transport = pollTracker{next: client.Transport}
+	bot, err := tgbotapi.NewBotAPIWithClient(botToken, client)
+	if err != nil {
+		slog.Error("can't authorize bot", "err", err)
+		os.Exit(1)
 	}
+	bot.Debug = debug
+	slog.Info("authorized", "account", bot.Self.UserName)
+	shutdown, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	ticker := time.Tick(30 * time.Second)
 	reload := make(chan bool, 100)
-	go checkHealth(ss)
-	go forTheWatch(dbstore, bot, reload)
+	out := newOutbox(bot)
+	alerts := newOnlineAlerts()
+	flaps := &statusFlaps{}
+	clock := &statusClock{}
+	leader := newLeadership(dbstore, instanceID)
+	leader.try(reload)
+	go leader.run(shutdown, reload)
+	updates := make(chan tgbotapi.Update)
+	go pollUpdates(shutdown, bot, leader, updates)
+	go watchPortal(shutdown, ss, 30*time.Second)
+	if ss.Probe != nil {
+		go ss.Probe.check(shutdown)
+	}
+	var newsFound chan newsBatch
+	if news := newNewsWatcher(newsURL, newsSelector, dbstore, leader); news != nil {
+		newsFound = news.found
+		go news.run(shutdown, newsInterval)
+	}
+	var clientFound chan clientUpdate
+	if client := newClientWatcher(clientURL, clientVersionRe, dbstore, leader); client != nil {
+		clientFound = client.found
+		go client.run(shutdown, clientInterval)
+	}
+	schedulerDone := make(chan struct{})
+	go func() {
+		forTheWatch(shutdown, dbstore, bot, leader, reload)
+		close(schedulerDone)
+	}()
+	var httpServer *http.Server
+	if httpAddr != "" {
+		if publicURL == "" {
+			publicURL = defaultPublicURL(httpAddr)
+		}
+		publicURL = strings.TrimSuffix(publicURL, "/")
+		httpServer = startHTTP(httpAddr, newHTTPMux(dbstore, reload))
+	} else {
+		publicURL = ""
+	}
 
+loop:
 	for {
 		select {
+		case <-shutdown.Done():
+			break loop
 		case update := <-updates:
-			log.Printf("%+v", update)
-			if update.CallbackQuery != nil {
-				buttonData := done
-				buttonText := "✓"
-				newMsgText := strings.Replace(update.CallbackQuery.Message.Text, "⏰", "✓", 1)
-				if update.CallbackQuery.Data == done {
-					buttonData = undone
-					buttonText = "✗"
-					newMsgText = strings.Replace(update.CallbackQuery.Message.Text, "✓", "⏰", 1)
-				}
-				markup := tgbotapi.InlineKeyboardMarkup{
-					InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{
-						[]tgbotapi.InlineKeyboardButton{
-							tgbotapi.InlineKeyboardButton{
-								Text:         buttonText,
-								CallbackData: &buttonData,
-							},
-						},
-					},
-				}
-				editConfig := tgbotapi.EditMessageTextConfig{
-					BaseEdit: tgbotapi.BaseEdit{
-						ChatID:      update.CallbackQuery.Message.Chat.ID,
-						MessageID:   update.CallbackQuery.Message.MessageID,
-						ReplyMarkup: &markup,
-					},
-					Text: newMsgText,
-				}
-				log.Printf("%+v", editConfig)
-				bot.Send(editConfig)
+			ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+			handleUpdate(ctx, bot, dbstore, ss, reload, update)
+			cancel()
+		case <-ticker:
+			if ss.Probe != nil {
+				go ss.Probe.check(shutdown)
 			}
-			if update.Message == nil {
-				continue
+			if notice, ok := flaps.settle(time.Now(), ss.State().Status); ok && leader.IsLeader() {
+				ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+				broadcastStatus(ctx, dbstore, out, notice)
+				cancel()
 			}
-			UserName := update.Message.From.UserName
-			UserID := update.Message.From.ID
-			ChatID := update.Message.Chat.ID
-			strs := strings.Split(update.Message.Text, " ")
-			command := strings.Split(strings.ToLower(strs[0]), "@")[0]
-			body := strings.Join(strs[1:], " ")
-
-			if command == "" {
-				continue
-			} else if command == "/status" {
-				reply := fmt.Sprintf("Status: %s", ss.Status)
-				msg := tgbotapi.NewMessage(ChatID, reply)
-				bot.Send(msg)
-			} else if command == "/online" {
-				reply := fmt.Sprintf("Online: %s", ss.Online)
-				msg := tgbotapi.NewMessage(ChatID, reply)
-				bot.Send(msg)
-			} else if command == "/statuson" {
-				err := dbstore.AppendToSSList(ChatID)
-				var reply string
-				if err == nil {
-					reply = "Now you will receive server statuses on server change\n/statusoff to disable"
-				} else {
-					reply = fmt.Sprintf("Error: %s", err)
-				}
-				msg := tgbotapi.NewMessage(ChatID, reply)
-				bot.Send(msg)
-			} else if command == "/statusoff" {
-				dbstore.DeleteFromSSList(ChatID)
-				reply := "Now you will NOT receive server statuses on server change\n/statuson to enable"
-				msg := tgbotapi.NewMessage(ChatID, reply)
-				bot.Send(msg)
-			} else if command == "/timer" { // dirty shit
-				if len(strs) < 3 {
-					bot.Send(tgbotapi.NewMessage(ChatID, "send me timer in following format:\n /timer text 15m"))
-					continue
-				}
-				description := strings.Join(strs[1:len(strs)-1], " ")
-				delayTwoWords := strings.Join(strs[len(strs)-2:], " ")
-				delay := strs[len(strs)-1]
-				reply := ""
-				ok := false
-				duration, err := parseDuration(delay)
-				var fireAt time.Time
-				if err != nil {
-					fireAt, err = parseDateTime(delayTwoWords)
-					description = strings.Join(strs[1:len(strs)-2], " ")
-					if err != nil {
-						fmt.Printf("err1: %s\n", err)
-						fireAt, err = parseDateTime(delay)
-						description = strings.Join(strs[1:len(strs)-1], " ")
-						if err != nil {
-							reply = fmt.Sprintf("error: '%s'\n", err)
-						}
-					}
-					if fireAt.Before(time.Now()) {
-						reply = fmt.Sprintf("error: time is in past")
-					} else {
-						ok = true
-					}
-				} else {
-					fireAt = time.Now().Add(duration)
-					ok = true
-				}
-
-				if ok {
-					if description == "" {
-						reply = "error: timer have no text"
-					} else {
-						timer := &timer.Timer{
-							At:     fireAt,
-							Body:   description,
-							ChatID: ChatID,
-						}
-						err = dbstore.SaveTimer(timer)
-						if err != nil {
-							log.Println(err.Error())
-							reply = fmt.Sprintf("error:\n%s", err)
-						} else {
-							reply = fmt.Sprintf("⏲ fire at %s", fireAt.In(location).Format("2006-01-02 15:04:05 MST"))
-							reload <- true
-						}
-					}
+		case batch := <-newsFound:
+			// unsaved items are found again by the next check
+			ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+			if err := broadcastNews(ctx, dbstore, out, batch.Items); err != nil {
+				slog.Error("can't post news, will retry", "err", err)
+			} else if err = batch.seen(ctx, dbstore); err != nil {
+				slog.Error("can't save last seen news, they may be posted again", "err", err)
+			}
+			cancel()
+		case update := <-clientFound:
+			ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+			if err := broadcastClient(ctx, dbstore, out, update); err != nil {
+				slog.Error("can't post client update, will retry", "err", err)
+			} else if err = update.seen(ctx, dbstore); err != nil {
+				slog.Error("can't save client version, update may be posted again", "err", err)
+			}
+			cancel()
+		case ch := <-ss.ChangedState:
+			old, cur := ch.Old, ch.New
+			var change statusChange
+			var notice string
+			report := false
+			if old.Status != cur.Status {
+				change = clock.change(time.Now(), old.Status, cur)
+				report, notice = flaps.change(time.Now(), cur.Status)
+			}
+			// every instance follows alert state, so a new leader doesn't
+			// resend alerts the old one has sent
+			isLeader := leader.IsLeader()
+			ctx, cancel := context.WithTimeout(context.Background(), storageTimeout)
+			timers, chats, err := watchers.get(ctx, dbstore)
+			if err != nil {
+				slog.Error("can't get state watchers", "err", err)
+			} else {
+				if old.Online != cur.Online {
+					alerts.check(out, chats, cur, isLeader)
 				}
-				bot.Send(tgbotapi.NewMessage(ChatID, reply))
-			} else if command == "/timerlist" {
-				timers, _ := dbstore.ListChatTimers(ChatID)
-				var reply bytes.Buffer
-				if len(timers) == 0 {
-					reply.WriteString("No timers here yet")
-				} else {
-					for i, t := range timers {
-						reply.WriteString(fmt.Sprintf("⏲ %s\n%s\n%s\n\n", t.At.In(location).Format("2006-01-02 15:04:05 MST"), t.Body, t.ID))
-						log.Printf("timer[%d]: '%v'\n", i, t)
-					}
+				if isLeader {
+					fireTriggers(ctx, dbstore, out, timers, old, cur)
 				}
-				bot.Send(tgbotapi.NewMessage(ChatID, reply.String()))
-			} else if command == "/timerdel" {
-				err := dbstore.DeleteTimer(ChatID, body)
-				if err != nil {
-					bot.Send(tgbotapi.NewMessage(ChatID, err.Error()))
+			}
+			cancel()
+			if !isLeader {
+				break
+			}
+			if report {
+				ctx, cancel = context.WithTimeout(context.Background(), storageTimeout)
+				if notice != "" {
+					broadcastStatus(ctx, dbstore, out, notice)
 				} else {
-					bot.Send(tgbotapi.NewMessage(ChatID, "Done!"))
+					broadcastChange(ctx, dbstore, out, change)
 				}
-			} else {
-				reply := fmt.Sprintf("Unknown command: '%s'", command)
-				msg := tgbotapi.NewMessage(ChatID, reply)
-				bot.Send(msg)
-			}
-			log.Printf("[%s] <%d> (%d) %s", UserName, ChatID, UserID, update.Message.Text)
-		case <-ticker:
-			go checkHealth(ss)
-		case oldStatus := <-ss.ChangedState:
-			for _, chatID := range dbstore.GetSSChats() {
-				log.Printf("Sending to chat %d", chatID)
-				msgText := fmt.Sprintf("'%s'\n=>\n'%s'", oldStatus, ss.Status)
-				msg := tgbotapi.NewMessage(chatID, msgText)
-				bot.Send(msg)
+				cancel()
 			}
 		}
 	}
+
+	slog.Info("shutting down", "timeout", shutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if httpServer != nil {
+		if err = httpServer.Shutdown(ctx); err != nil {
+			slog.Warn("HTTP server did not stop in time", "err", err)
+		}
+	}
+	select {
+	case <-schedulerDone:
+	case <-ctx.Done():
+		slog.Warn("scheduler did not stop in time")
+	}
+	leader.release(ctx)
+	if unsent := out.Flush(ctx); unsent > 0 {
+		slog.Warn("outbox not flushed in time", "unsent", unsent)
+	}
+	if err = dbstore.Close(); err != nil {
+		slog.Warn("can't close storage", "err", err)
+	}
+	slog.Info("bye")
 }
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mementor/hafenbot/storage"
+	"github.com/mementor/hafenbot/timer"
+)
+
+func TestMain(m *testing.M) {
+	location = time.FixedZone("MSK", 3*60*60)
+	os.Exit(m.Run())
+}
+
+// memStore is in-memory storage for tests. Methods not implemented here
+// panic on the nil embedded interface
+type memStore struct {
+	storage.Storage
+	mu     sync.Mutex
+	seq    int
+	timers map[string]timer.Timer
+	tokens map[string]int64
+	marks  map[string]string
+	// onGet is called with stored timer after GetTimerByChatAndID read it
+	onGet func(*timer.Timer)
+}
+
+func newMemStore() *memStore {
+	return &memStore{timers: make(map[string]timer.Timer), tokens: make(map[string]int64), marks: make(map[string]string)}
+}
+
+func (m *memStore) SaveTimer(ctx context.Context, t *timer.Timer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t.ID == "" {
+		m.seq++
+		t.ID = fmt.Sprintf("t%d", m.seq)
+	}
+	if t.Due.IsZero() {
+		t.Due = t.NextDue()
+	}
+	m.timers[t.ID] = *t
+	return nil
+}
+
+func (m *memStore) DeleteTimer(ctx context.Context, chatID int64, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t, ok := m.timers[id]; !ok || t.ChatID != chatID {
+		return storage.ErrNotFound
+	}
+	delete(m.timers, id)
+	return nil
+}
+
+func (m *memStore) UpdateTimer(ctx context.Context, t *timer.Timer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.timers[t.ID]
+	if !ok || stored.ChatID != t.ChatID || !stored.Due.Equal(t.Due) {
+		return storage.ErrConflict
+	}
+	t.Due = t.NextDue()
+	m.timers[t.ID] = *t
+	return nil
+}
+
+func (m *memStore) ListChatTimers(ctx context.Context, chatID int64) ([]timer.Timer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var timers []timer.Timer
+	for _, t := range m.timers {
+		if t.ChatID == chatID {
+			timers = append(timers, t)
+		}
+	}
+	sort.Slice(timers, func(i, j int) bool { return timers[i].At.Before(timers[j].At) })
+	return timers, nil
+}
+
+func (m *memStore) GetTimerByChatAndID(ctx context.Context, chatID int64, id string) (*timer.Timer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.timers[id]
+	if !ok || t.ChatID != chatID {
+		return nil, storage.ErrNotFound
+	}
+	if m.onGet != nil {
+		stored := t
+		m.onGet(&stored)
+		m.timers[id] = stored
+	}
+	return &t, nil
+}
+
+func (m *memStore) CreateChatToken(ctx context.Context, kind string, chatID int64, hash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens[kind+":"+hash] = chatID
+	return nil
+}
+
+func (m *memStore) DeleteChatToken(ctx context.Context, kind string, chatID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, id := range m.tokens {
+		if id == chatID && strings.HasPrefix(key, kind+":") {
+			delete(m.tokens, key)
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (m *memStore) GetChatByToken(ctx context.Context, kind string, hash string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	chatID, ok := m.tokens[kind+":"+hash]
+	if !ok {
+		return 0, storage.ErrNotFound
+	}
+	return chatID, nil
+}
+
+func (m *memStore) GetWatermark(ctx context.Context, name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, ok := m.marks[name]
+	if !ok {
+		return "", storage.ErrNotFound
+	}
+	return value, nil
+}
+
+func (m *memStore) SaveWatermark(ctx context.Context, name, value string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.marks[name] = value
+	return nil
+}
@@ -0,0 +1,157 @@
+// Package metrics holds Prometheus collectors of the bot
+package metrics
+
+import (
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "hafenbot"
+
+var (
+	// Updates counts handled Telegram updates by command
+	Updates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "updates_total",
+		Help:      "Telegram updates handled, by command.",
+	}, []string{"command"})
+
+	// TimersCreated counts created timers by the way they were created
+	TimersCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "timers_created_total",
+		Help:      "Timers created, by source.",
+	}, []string{"source"})
+
+	// TimersFired counts timers sent to chats
+	TimersFired = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "timers_fired_total",
+		Help:      "Timers fired.",
+	})
+
+	// TimersDeleted counts timers deleted by users
+	TimersDeleted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "timers_deleted_total",
+		Help:      "Timers deleted before firing, by source.",
+	}, []string{"source"})
+
+	// FireLateness observes delay between timer fire time and actual firing
+	FireLateness = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "timer_fire_lateness_seconds",
+		Help:      "Delay between timer fire time and its actual firing.",
+		Buckets:   []float64{0.1, 0.5, 1, 2, 5, 10, 15, 30, 60, 300, 900},
+	})
+
+	// StorageDuration observes storage calls by driver and method
+	StorageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "storage_call_duration_seconds",
+		Help:      "Duration of storage calls, by driver and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"driver", "method"})
+
+	// StorageErrors counts failed storage calls by driver and method
+	StorageErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "storage_call_errors_total",
+		Help:      "Failed storage calls, by driver and method.",
+	}, []string{"driver", "method"})
+
+	// TelegramFailures counts failed Telegram API requests by API method
+	TelegramFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "telegram_request_failures_total",
+		Help:      "Failed Telegram API requests, by API method.",
+	}, []string{"method"})
+
+	// Scrapes counts portal scrapes by result
+	Scrapes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "portal_scrapes_total",
+		Help:      "Portal status page scrapes, by result.",
+	}, []string{"result"})
+
+	// ScrapeDuration observes portal scrapes
+	ScrapeDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "portal_scrape_duration_seconds",
+		Help:      "Duration of portal status page scrapes.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	// GameProbes counts TCP probes of game server by result
+	GameProbes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "game_probes_total",
+		Help:      "TCP connect probes of game server, by result.",
+	}, []string{"result"})
+
+	// GameProbeLatency observes connect time of successful game server probes
+	GameProbeLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "game_probe_latency_seconds",
+		Help:      "Connect time of successful TCP probes of game server.",
+		Buckets:   []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2, 5},
+	})
+
+	// GameReachable is 1 while game server accepts connections
+	GameReachable = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "game_reachable",
+		Help:      "Whether game server accepts TCP connections.",
+	})
+
+	// Leader is 1 while this instance holds leader lease
+	Leader = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "leader",
+		Help:      "Whether this instance is the leader firing timers and broadcasting status.",
+	})
+)
+
+// Handler serves metrics in Prometheus format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveScrape records portal scrape started at start
+func ObserveScrape(start time.Time, err error) {
+	ScrapeDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		Scrapes.WithLabelValues("failure").Inc()
+		return
+	}
+	Scrapes.WithLabelValues("success").Inc()
+}
+
+// telegramTransport counts failed Telegram API requests
+type telegramTransport struct {
+	next http.RoundTripper
+}
+
+// TelegramClient returns HTTP client for Telegram API which counts failures
+func TelegramClient() *http.Client {
+	return &http.Client{Transport: telegramTransport{next: http.DefaultTransport}}
+}
+
+func (t telegramTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	// last path element is API method, the rest contains bot token
+	method := path.Base(req.URL.Path)
+	if err != nil {
+		TelegramFailures.WithLabelValues(method).Inc()
+		return resp, err
+	}
+	if resp.StatusCode >= 400 {
+		TelegramFailures.WithLabelValues(method).Inc()
+	}
+	return resp, nil
+}
@@ -0,0 +1,304 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mementor/hafenbot/plot"
+	"github.com/mementor/hafenbot/settings"
+	"github.com/mementor/hafenbot/storage"
+	"github.com/mementor/hafenbot/timer"
+)
+
+// instrumentedStorage measures calls of wrapped storage
+type instrumentedStorage struct {
+	driver string
+	next   storage.Storage
+}
+
+// Storage wraps s so that its calls are measured under driver label
+func Storage(driver string, s storage.Storage) storage.Storage {
+	return &instrumentedStorage{driver: driver, next: s}
+}
+
+// observe records call of method started at start. Expected outcomes like
+// missing timer, repeated subscription or lost race for a timer are not
+// counted as errors
+func (s *instrumentedStorage) observe(method string, start time.Time, err error) {
+	StorageDuration.WithLabelValues(s.driver, method).Observe(time.Since(start).Seconds())
+	if err != nil && !errors.Is(err, storage.ErrNotFound) && !errors.Is(err, storage.ErrAlreadySubscribed) &&
+		!errors.Is(err, storage.ErrConflict) {
+		StorageErrors.WithLabelValues(s.driver, method).Inc()
+	}
+}
+
+func (s *instrumentedStorage) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := s.next.Ping(ctx)
+	s.observe("Ping", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) Close() error {
+	return s.next.Close()
+}
+
+func (s *instrumentedStorage) SaveTimer(ctx context.Context, t *timer.Timer) error {
+	start := time.Now()
+	err := s.next.SaveTimer(ctx, t)
+	s.observe("SaveTimer", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) DeleteTimer(ctx context.Context, chatID int64, id string) error {
+	start := time.Now()
+	err := s.next.DeleteTimer(ctx, chatID, id)
+	s.observe("DeleteTimer", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) UpdateTimer(ctx context.Context, t *timer.Timer) error {
+	start := time.Now()
+	err := s.next.UpdateTimer(ctx, t)
+	s.observe("UpdateTimer", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) GetNearestTimer(ctx context.Context) (*timer.Timer, error) {
+	start := time.Now()
+	res, err := s.next.GetNearestTimer(ctx)
+	s.observe("GetNearestTimer", start, err)
+	return res, err
+}
+
+func (s *instrumentedStorage) ClaimTimer(ctx context.Context, t *timer.Timer, until time.Time) error {
+	start := time.Now()
+	err := s.next.ClaimTimer(ctx, t, until)
+	s.observe("ClaimTimer", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) RescheduleTimer(ctx context.Context, t *timer.Timer, due time.Time) error {
+	start := time.Now()
+	err := s.next.RescheduleTimer(ctx, t, due)
+	s.observe("RescheduleTimer", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) ListChatTimers(ctx context.Context, chatID int64) ([]timer.Timer, error) {
+	start := time.Now()
+	res, err := s.next.ListChatTimers(ctx, chatID)
+	s.observe("ListChatTimers", start, err)
+	return res, err
+}
+
+func (s *instrumentedStorage) ListTriggeredTimers(ctx context.Context) ([]timer.Timer, error) {
+	start := time.Now()
+	res, err := s.next.ListTriggeredTimers(ctx)
+	s.observe("ListTriggeredTimers", start, err)
+	return res, err
+}
+
+func (s *instrumentedStorage) ListAssignedTimers(ctx context.Context, userID int, username string) ([]timer.Timer, error) {
+	start := time.Now()
+	res, err := s.next.ListAssignedTimers(ctx, userID, username)
+	s.observe("ListAssignedTimers", start, err)
+	return res, err
+}
+
+func (s *instrumentedStorage) GetTimerByChatAndID(ctx context.Context, chatID int64, id string) (*timer.Timer, error) {
+	start := time.Now()
+	res, err := s.next.GetTimerByChatAndID(ctx, chatID, id)
+	s.observe("GetTimerByChatAndID", start, err)
+	return res, err
+}
+
+func (s *instrumentedStorage) WalkTimers(ctx context.Context, fn func(*timer.Timer) error) error {
+	start := time.Now()
+	err := s.next.WalkTimers(ctx, fn)
+	s.observe("WalkTimers", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) AppendToSSList(ctx context.Context, chatID int64) error {
+	start := time.Now()
+	err := s.next.AppendToSSList(ctx, chatID)
+	s.observe("AppendToSSList", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) DeleteFromSSList(ctx context.Context, chatID int64) error {
+	start := time.Now()
+	err := s.next.DeleteFromSSList(ctx, chatID)
+	s.observe("DeleteFromSSList", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) GetSSChats(ctx context.Context) ([]int64, error) {
+	start := time.Now()
+	res, err := s.next.GetSSChats(ctx)
+	s.observe("GetSSChats", start, err)
+	return res, err
+}
+
+func (s *instrumentedStorage) AppendToNewsList(ctx context.Context, chatID int64) error {
+	start := time.Now()
+	err := s.next.AppendToNewsList(ctx, chatID)
+	s.observe("AppendToNewsList", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) DeleteFromNewsList(ctx context.Context, chatID int64) error {
+	start := time.Now()
+	err := s.next.DeleteFromNewsList(ctx, chatID)
+	s.observe("DeleteFromNewsList", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) GetNewsChats(ctx context.Context) ([]int64, error) {
+	start := time.Now()
+	res, err := s.next.GetNewsChats(ctx)
+	s.observe("GetNewsChats", start, err)
+	return res, err
+}
+
+func (s *instrumentedStorage) AppendToClientList(ctx context.Context, chatID int64) error {
+	start := time.Now()
+	err := s.next.AppendToClientList(ctx, chatID)
+	s.observe("AppendToClientList", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) DeleteFromClientList(ctx context.Context, chatID int64) error {
+	start := time.Now()
+	err := s.next.DeleteFromClientList(ctx, chatID)
+	s.observe("DeleteFromClientList", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) GetClientChats(ctx context.Context) ([]int64, error) {
+	start := time.Now()
+	res, err := s.next.GetClientChats(ctx)
+	s.observe("GetClientChats", start, err)
+	return res, err
+}
+
+func (s *instrumentedStorage) GetWatermark(ctx context.Context, name string) (string, error) {
+	start := time.Now()
+	res, err := s.next.GetWatermark(ctx, name)
+	s.observe("GetWatermark", start, err)
+	return res, err
+}
+
+func (s *instrumentedStorage) SaveWatermark(ctx context.Context, name string, value string) error {
+	start := time.Now()
+	err := s.next.SaveWatermark(ctx, name, value)
+	s.observe("SaveWatermark", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) GetChatSettings(ctx context.Context, chatID int64) (*settings.Chat, error) {
+	start := time.Now()
+	res, err := s.next.GetChatSettings(ctx, chatID)
+	s.observe("GetChatSettings", start, err)
+	return res, err
+}
+
+func (s *instrumentedStorage) SaveChatSettings(ctx context.Context, c *settings.Chat) error {
+	start := time.Now()
+	err := s.next.SaveChatSettings(ctx, c)
+	s.observe("SaveChatSettings", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) ListChatSettings(ctx context.Context) ([]settings.Chat, error) {
+	start := time.Now()
+	res, err := s.next.ListChatSettings(ctx)
+	s.observe("ListChatSettings", start, err)
+	return res, err
+}
+
+func (s *instrumentedStorage) CreateChatToken(ctx context.Context, kind string, chatID int64, hash string) error {
+	start := time.Now()
+	err := s.next.CreateChatToken(ctx, kind, chatID, hash)
+	s.observe("CreateChatToken", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) ReplaceChatToken(ctx context.Context, kind string, chatID int64, hash string) error {
+	start := time.Now()
+	err := s.next.ReplaceChatToken(ctx, kind, chatID, hash)
+	s.observe("ReplaceChatToken", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) DeleteChatToken(ctx context.Context, kind string, chatID int64) error {
+	start := time.Now()
+	err := s.next.DeleteChatToken(ctx, kind, chatID)
+	s.observe("DeleteChatToken", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) GetChatByToken(ctx context.Context, kind string, hash string) (int64, error) {
+	start := time.Now()
+	res, err := s.next.GetChatByToken(ctx, kind, hash)
+	s.observe("GetChatByToken", start, err)
+	return res, err
+}
+
+func (s *instrumentedStorage) SavePlot(ctx context.Context, p *plot.Plot) error {
+	start := time.Now()
+	err := s.next.SavePlot(ctx, p)
+	s.observe("SavePlot", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) ListChatPlots(ctx context.Context, chatID int64) ([]plot.Plot, error) {
+	start := time.Now()
+	res, err := s.next.ListChatPlots(ctx, chatID)
+	s.observe("ListChatPlots", start, err)
+	return res, err
+}
+
+func (s *instrumentedStorage) DeletePlot(ctx context.Context, chatID int64, name string) error {
+	start := time.Now()
+	err := s.next.DeletePlot(ctx, chatID, name)
+	s.observe("DeletePlot", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) AcquireLease(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error) {
+	start := time.Now()
+	res, err := s.next.AcquireLease(ctx, name, holder, ttl)
+	s.observe("AcquireLease", start, err)
+	return res, err
+}
+
+func (s *instrumentedStorage) ReleaseLease(ctx context.Context, name string, holder string) error {
+	start := time.Now()
+	err := s.next.ReleaseLease(ctx, name, holder)
+	s.observe("ReleaseLease", start, err)
+	return err
+}
+
+func (s *instrumentedStorage) ListWatermarks(ctx context.Context) (map[string]string, error) {
+	start := time.Now()
+	res, err := s.next.ListWatermarks(ctx)
+	s.observe("ListWatermarks", start, err)
+	return res, err
+}
+
+func (s *instrumentedStorage) ListChatTokens(ctx context.Context) ([]storage.ChatToken, error) {
+	start := time.Now()
+	res, err := s.next.ListChatTokens(ctx)
+	s.observe("ListChatTokens", start, err)
+	return res, err
+}
+
+func (s *instrumentedStorage) ListPlots(ctx context.Context) ([]plot.Plot, error) {
+	start := time.Now()
+	res, err := s.next.ListPlots(ctx)
+	s.observe("ListPlots", start, err)
+	return res, err
+}
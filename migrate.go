@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mementor/hafenbot/plot"
+	"github.com/mementor/hafenbot/settings"
+	"github.com/mementor/hafenbot/storage"
+	"github.com/mementor/hafenbot/timer"
+)
+
+// migrateProgressEvery is how often migrate reports copied timers
+const migrateProgressEvery = 100
+
+// migrateCount counts entries of one kind
+type migrateCount struct {
+	read    int
+	copied  int
+	existed int
+}
+
+// add counts result of copying an entry, exists tells that it is already
+// in destination. Other errors are returned wrapped with what
+func (c *migrateCount) add(err error, exists bool, what string) error {
+	switch {
+	case exists:
+		c.existed++
+	case err != nil:
+		return fmt.Errorf("%s: %w", what, err)
+	default:
+		c.copied++
+	}
+	return nil
+}
+
+func (c migrateCount) String() string {
+	return fmt.Sprintf("%d read, %d copied, %d already there", c.read, c.copied, c.existed)
+}
+
+// subList is a list of chats subscribed to notices of some kind
+type subList struct {
+	name   string
+	get    func(storage.Storage, context.Context) ([]int64, error)
+	append func(storage.Storage, context.Context, int64) error
+}
+
+var subLists = []subList{
+	{"server status subscriptions", storage.Storage.GetSSChats, storage.Storage.AppendToSSList},
+	{"news subscriptions", storage.Storage.GetNewsChats, storage.Storage.AppendToNewsList},
+	{"client subscriptions", storage.Storage.GetClientChats, storage.Storage.AppendToClientList},
+}
+
+type migrateStats struct {
+	timers     migrateCount
+	subs       []migrateCount
+	settings   migrateCount
+	tokens     migrateCount
+	plots      migrateCount
+	watermarks migrateCount
+}
+
+// migrateSource is what was read from source, to be found in destination
+type migrateSource struct {
+	timerIDs   map[string]bool
+	subs       [][]int64
+	settings   []settings.Chat
+	tokens     []storage.ChatToken
+	plots      []plot.Plot
+	watermarks map[string]string
+}
+
+// migrate copies timers, subscriptions, chat settings, API and feed tokens,
+// crop plots and watcher watermarks from one storage driver to another.
+// Leases are not copied, they expire in seconds and instances take them
+// again. IDs and fire times are preserved, entries already present in
+// destination are skipped, so it is safe to run it again after a failure.
+// Returns process exit code.
+func migrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	var from, to, mongosrv, fromMongo, toMongo string
+	var dryRun bool
+	fs.StringVar(&from, "from", "", "Source database driver (mongo or dynamo)")
+	fs.StringVar(&to, "to", "", "Destination database driver (mongo or dynamo)")
+	fs.StringVar(&mongosrv, "mongosrv", "", "Address of mongo servers")
+	fs.StringVar(&fromMongo, "from-mongosrv", "", "Address of source mongo servers (defaults to --mongosrv)")
+	fs.StringVar(&toMongo, "to-mongosrv", "", "Address of destination mongo servers (defaults to --mongosrv)")
+	fs.BoolVar(&dryRun, "dry-run", false, "Only read source and report what would be copied")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fromMongo == "" {
+		fromMongo = mongosrv
+	}
+	if toMongo == "" {
+		toMongo = mongosrv
+	}
+	if from == "" || to == "" {
+		fmt.Fprintln(os.Stderr, "usage: hafenbot migrate --from=dynamo --to=mongo [--dry-run]")
+		return 2
+	}
+	if from == to && fromMongo == toMongo {
+		fmt.Fprintln(os.Stderr, "source and destination are the same storage")
+		return 2
+	}
+
+	src, err := openStorage(from, fromMongo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open source: %s\n", err)
+		return 1
+	}
+	var dst storage.Storage
+	if !dryRun {
+		dst, err = openStorage(to, toMongo)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "open destination: %s\n", err)
+			return 1
+		}
+	}
+
+	ctx := context.Background()
+	stats, source, err := copyStorage(ctx, src, dst)
+	fmt.Printf("timers: %s\n", stats.timers)
+	for i, c := range stats.subs {
+		fmt.Printf("%s: %s\n", subLists[i].name, c)
+	}
+	fmt.Printf("chat settings: %s\n", stats.settings)
+	fmt.Printf("chat tokens: %s\n", stats.tokens)
+	fmt.Printf("plots: %s\n", stats.plots)
+	fmt.Printf("watermarks: %s\n", stats.watermarks)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migration failed: %s\n", err)
+		return 1
+	}
+	if dryRun {
+		fmt.Println("dry run, nothing was written")
+		return 0
+	}
+
+	if err = verifyMigration(ctx, dst, source); err != nil {
+		fmt.Fprintf(os.Stderr, "verification failed: %s\n", err)
+		return 1
+	}
+	fmt.Println("verification passed")
+	return 0
+}
+
+// copyStorage streams everything from src to dst. Nil dst means dry run.
+// Returns what was read from source for verification
+func copyStorage(ctx context.Context, src, dst storage.Storage) (stats migrateStats, source migrateSource, err error) {
+	source.timerIDs = make(map[string]bool)
+	err = src.WalkTimers(ctx, func(t *timer.Timer) error {
+		stats.timers.read++
+		source.timerIDs[t.ID] = true
+		if dst != nil {
+			sctx, cancel := context.WithTimeout(ctx, storageTimeout)
+			err := dst.SaveTimer(sctx, t)
+			cancel()
+			if err = stats.timers.add(err, errors.Is(err, storage.ErrConflict), "timer "+t.ID); err != nil {
+				return err
+			}
+		}
+		if stats.timers.read%migrateProgressEvery == 0 {
+			fmt.Printf("%s: %d timers processed\n", time.Now().Format("15:04:05"), stats.timers.read)
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	stats.subs = make([]migrateCount, len(subLists))
+	source.subs = make([][]int64, len(subLists))
+	for i, list := range subLists {
+		sctx, cancel := context.WithTimeout(ctx, storageTimeout)
+		chats, err := list.get(src, sctx)
+		cancel()
+		if err != nil {
+			return stats, source, fmt.Errorf("%s: %w", list.name, err)
+		}
+		source.subs[i] = chats
+		for _, chatID := range chats {
+			stats.subs[i].read++
+			if dst == nil {
+				continue
+			}
+			sctx, cancel := context.WithTimeout(ctx, storageTimeout)
+			err = list.append(dst, sctx, chatID)
+			cancel()
+			if err = stats.subs[i].add(err, errors.Is(err, storage.ErrAlreadySubscribed), fmt.Sprintf("%s of %d", list.name, chatID)); err != nil {
+				return stats, source, err
+			}
+		}
+	}
+
+	sctx, cancel := context.WithTimeout(ctx, storageTimeout)
+	source.settings, err = src.ListChatSettings(sctx)
+	cancel()
+	if err != nil {
+		return
+	}
+	for i := range source.settings {
+		c := &source.settings[i]
+		stats.settings.read++
+		if dst == nil {
+			continue
+		}
+		// settings are upserted, so check first not to overwrite newer ones
+		sctx, cancel := context.WithTimeout(ctx, storageTimeout)
+		_, err = dst.GetChatSettings(sctx, c.ChatID)
+		exists := err == nil
+		if errors.Is(err, storage.ErrNotFound) {
+			err = dst.SaveChatSettings(sctx, c)
+		}
+		cancel()
+		if err = stats.settings.add(err, exists, fmt.Sprintf("settings of %d", c.ChatID)); err != nil {
+			return
+		}
+	}
+
+	sctx, cancel = context.WithTimeout(ctx, storageTimeout)
+	source.tokens, err = src.ListChatTokens(sctx)
+	cancel()
+	if err != nil {
+		return
+	}
+	for _, t := range source.tokens {
+		stats.tokens.read++
+		if dst == nil {
+			continue
+		}
+		sctx, cancel := context.WithTimeout(ctx, storageTimeout)
+		err = dst.CreateChatToken(sctx, t.Kind, t.ChatID, t.Hash)
+		cancel()
+		if err = stats.tokens.add(err, errors.Is(err, storage.ErrConflict), fmt.Sprintf("%s token of %d", t.Kind, t.ChatID)); err != nil {
+			return
+		}
+	}
+
+	sctx, cancel = context.WithTimeout(ctx, storageTimeout)
+	source.plots, err = src.ListPlots(sctx)
+	cancel()
+	if err != nil {
+		return
+	}
+	for i := range source.plots {
+		p := &source.plots[i]
+		stats.plots.read++
+		if dst == nil {
+			continue
+		}
+		sctx, cancel := context.WithTimeout(ctx, storageTimeout)
+		err = dst.SavePlot(sctx, p)
+		cancel()
+		if err = stats.plots.add(err, errors.Is(err, storage.ErrConflict), fmt.Sprintf("plot %s of %d", p.Name, p.ChatID)); err != nil {
+			return
+		}
+	}
+
+	sctx, cancel = context.WithTimeout(ctx, storageTimeout)
+	source.watermarks, err = src.ListWatermarks(sctx)
+	cancel()
+	if err != nil {
+		return
+	}
+	for name, value := range source.watermarks {
+		stats.watermarks.read++
+		if dst == nil {
+			continue
+		}
+		sctx, cancel := context.WithTimeout(ctx, storageTimeout)
+		_, err = dst.GetWatermark(sctx, name)
+		exists := err == nil
+		if errors.Is(err, storage.ErrNotFound) {
+			err = dst.SaveWatermark(sctx, name, value)
+		}
+		cancel()
+		if err = stats.watermarks.add(err, exists, "watermark "+name); err != nil {
+			return
+		}
+	}
+	return stats, source, nil
+}
+
+// checkFound reports how many of want keys are in have
+func checkFound[K comparable](what string, have map[K]bool, want []K) error {
+	missing := 0
+	for _, k := range want {
+		if !have[k] {
+			missing++
+		}
+	}
+	fmt.Printf("destination has %d %s, %d of %d source %s found\n", len(have), what, len(want)-missing, len(want), what)
+	if missing > 0 {
+		return fmt.Errorf("%d %s missing", missing, what)
+	}
+	return nil
+}
+
+// verifyMigration checks that everything read from source is in dst
+func verifyMigration(ctx context.Context, dst storage.Storage, source migrateSource) error {
+	dstTimers := make(map[string]bool)
+	err := dst.WalkTimers(ctx, func(t *timer.Timer) error {
+		dstTimers[t.ID] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(source.timerIDs))
+	for id := range source.timerIDs {
+		ids = append(ids, id)
+	}
+	if err = checkFound("timers", dstTimers, ids); err != nil {
+		return err
+	}
+
+	for i, list := range subLists {
+		sctx, cancel := context.WithTimeout(ctx, storageTimeout)
+		dstChats, err := list.get(dst, sctx)
+		cancel()
+		if err != nil {
+			return err
+		}
+		subscribed := make(map[int64]bool, len(dstChats))
+		for _, chatID := range dstChats {
+			subscribed[chatID] = true
+		}
+		if err = checkFound(list.name, subscribed, source.subs[i]); err != nil {
+			return err
+		}
+	}
+
+	sctx, cancel := context.WithTimeout(ctx, storageTimeout)
+	dstSettings, err := dst.ListChatSettings(sctx)
+	cancel()
+	if err != nil {
+		return err
+	}
+	haveSettings := make(map[int64]bool, len(dstSettings))
+	for _, c := range dstSettings {
+		haveSettings[c.ChatID] = true
+	}
+	wantSettings := make([]int64, 0, len(source.settings))
+	for _, c := range source.settings {
+		wantSettings = append(wantSettings, c.ChatID)
+	}
+	if err = checkFound("chat settings", haveSettings, wantSettings); err != nil {
+		return err
+	}
+
+	sctx, cancel = context.WithTimeout(ctx, storageTimeout)
+	dstTokens, err := dst.ListChatTokens(sctx)
+	cancel()
+	if err != nil {
+		return err
+	}
+	haveTokens := make(map[string]bool, len(dstTokens))
+	for _, t := range dstTokens {
+		haveTokens[fmt.Sprintf("%s:%d", t.Kind, t.ChatID)] = true
+	}
+	wantTokens := make([]string, 0, len(source.tokens))
+	for _, t := range source.tokens {
+		wantTokens = append(wantTokens, fmt.Sprintf("%s:%d", t.Kind, t.ChatID))
+	}
+	if err = checkFound("chat tokens", haveTokens, wantTokens); err != nil {
+		return err
+	}
+
+	sctx, cancel = context.WithTimeout(ctx, storageTimeout)
+	dstPlots, err := dst.ListPlots(sctx)
+	cancel()
+	if err != nil {
+		return err
+	}
+	havePlots := make(map[string]bool, len(dstPlots))
+	for _, p := range dstPlots {
+		havePlots[fmt.Sprintf("%d:%s", p.ChatID, p.Name)] = true
+	}
+	wantPlots := make([]string, 0, len(source.plots))
+	for _, p := range source.plots {
+		wantPlots = append(wantPlots, fmt.Sprintf("%d:%s", p.ChatID, p.Name))
+	}
+	if err = checkFound("plots", havePlots, wantPlots); err != nil {
+		return err
+	}
+
+	sctx, cancel = context.WithTimeout(ctx, storageTimeout)
+	dstMarks, err := dst.ListWatermarks(sctx)
+	cancel()
+	if err != nil {
+		return err
+	}
+	haveMarks := make(map[string]bool, len(dstMarks))
+	for name := range dstMarks {
+		haveMarks[name] = true
+	}
+	wantMarks := make([]string, 0, len(source.watermarks))
+	for name := range source.watermarks {
+		wantMarks = append(wantMarks, name)
+	}
+	return checkFound("watermarks", haveMarks, wantMarks)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mementor/hafenbot/storage"
+	"github.com/mementor/hafenbot/timer"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+const (
+	// defaultNagCount is number of repeats when --nag has no count
+	defaultNagCount = 3
+	// maxNagCount limits repeats of a fired timer
+	maxNagCount = 20
+	// minNagEvery limits how often fired timer can be repeated
+	minNagEvery = time.Minute
+)
+
+// parseNag parses repeat interval with optional count like "10m" or "10mx5"
+func parseNag(str string) (every time.Duration, count int, err error) {
+	if str == "" {
+		return 0, 0, errors.New("no interval after --nag, e.g. --nag 10m or --nag 10mx5")
+	}
+	count = defaultNagCount
+	interval, countStr, withCount := strings.Cut(str, "x")
+	if withCount {
+		count, err = strconv.Atoi(countStr)
+		if err != nil || count < 1 {
+			return 0, 0, fmt.Errorf("bad repeat count '%s'", countStr)
+		}
+		if count > maxNagCount {
+			return 0, 0, fmt.Errorf("too many repeats, %d max", maxNagCount)
+		}
+	}
+	every, err = parseDuration(interval)
+	if err != nil {
+		return 0, 0, fmt.Errorf("bad repeat interval '%s'", interval)
+	}
+	if every < minNagEvery {
+		return 0, 0, fmt.Errorf("repeat interval is too short, %s min", formatDuration(minNagEvery))
+	}
+	return every.Round(time.Second), count, nil
+}
+
+// userName returns name to mention user by
+func userName(u *tgbotapi.User) string {
+	if u.UserName != "" {
+		return u.UserName
+	}
+	return strings.TrimSpace(u.FirstName + " " + u.LastName)
+}
+
+// mention returns HTML link notifying user even without username
+func mention(userID int, name string) string {
+	if name == "" {
+		name = "someone"
+	}
+	return fmt.Sprintf(`<a href="tg://user?id=%d">%s</a>`, userID, html.EscapeString(name))
+}
+
+// nagTimer re-sends fired timer which is not marked done yet and schedules
+// the next repeat. Timer is deleted after the last one
+func nagTimer(ctx context.Context, store storage.Storage, bot *tgbotapi.BotAPI, t *timer.Timer, lg *slog.Logger) {
+	reply := fmt.Sprintf("🔁 ⏰ %s\n%s", t.At.In(location).Format("2006-01-02 15:04:05 MST"), t.Body)
+	msg := tgbotapi.NewMessage(t.ChatID, reply)
+	addMentions(&msg, t, t.NagMention)
+	msg.BaseChat.ReplyMarkup = getInlineKeyboard(button{isDone: false, timerID: t.ID, timerChat: t.ChatID})
+	if err := sendToTarget(bot, t, msg, lg); err != nil {
+		lg.Warn("can't repeat fired timer, will retry", "attempt", t.Attempts, "retry_at", t.Due, "err", err)
+		return
+	}
+	t.Nagged++
+	lg.Info("fired timer repeated", "repeat", t.Nagged, "of", t.NagCount)
+
+	if t.Nagged >= t.NagCount {
+		err := store.DeleteTimer(ctx, t.ChatID, t.ID)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			lg.Error("can't delete repeated timer, it will be sent again", "err", err)
+		}
+		return
+	}
+	if err := store.RescheduleTimer(ctx, t, time.Now().Add(t.NagEvery)); err != nil {
+		lg.Error("can't schedule next repeat, it may be sent again", "err", err)
+	}
+}
+
+// stopNagging deletes fired timer marked done so it isn't repeated anymore
+func stopNagging(ctx context.Context, dbstore storage.Storage, chatID int64, timerID string) {
+	err := dbstore.DeleteTimer(ctx, chatID, timerID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return
+	}
+	if err != nil {
+		logger(ctx).Error("can't stop repeating timer", "chat_id", chatID, "timer_id", timerID, "err", err)
+		return
+	}
+	logger(ctx).Info("repeating timer marked done", "chat_id", chatID, "timer_id", timerID)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseNag(t *testing.T) {
+	tests := []struct {
+		str       string
+		wantEvery time.Duration
+		wantCount int
+		wantErr   bool
+	}{
+		{str: "10m", wantEvery: 10 * time.Minute, wantCount: defaultNagCount},
+		{str: "10mx5", wantEvery: 10 * time.Minute, wantCount: 5},
+		{str: "1h30mx1", wantEvery: 90 * time.Minute, wantCount: 1},
+		{str: "1mx20", wantEvery: time.Minute, wantCount: maxNagCount},
+		{str: "0.5h", wantEvery: 30 * time.Minute, wantCount: defaultNagCount},
+		{str: "1mx21", wantErr: true},
+		{str: "10mx0", wantErr: true},
+		{str: "10mx-1", wantErr: true},
+		{str: "10mx", wantErr: true},
+		{str: "10mxfive", wantErr: true},
+		{str: "59s", wantErr: true},
+		{str: "30sx3", wantErr: true},
+		{str: "x3", wantErr: true},
+		{str: "often", wantErr: true},
+		{str: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.str, func(t *testing.T) {
+			every, count, err := parseNag(tt.str)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if every != tt.wantEvery || count != tt.wantCount {
+				t.Errorf("got every %v count %d, want %v and %d", every, count, tt.wantEvery, tt.wantCount)
+			}
+		})
+	}
+}
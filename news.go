@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mementor/hafenbot/storage"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+const (
+	// newsTimeout limits fetch of news page
+	newsTimeout = 30 * time.Second
+	// maxNewsPage limits size of news page
+	maxNewsPage = 5 << 20
+	// maxNewsItems limits announcements posted at once, e.g. when last
+	// seen item is gone from the feed
+	maxNewsItems = 3
+)
+
+// newsItem is an announcement found on news page, newest first
+type newsItem struct {
+	ID    string
+	Title string
+	Link  string
+}
+
+// feedDoc holds items of RSS and entries of Atom feed
+type feedDoc struct {
+	Items []struct {
+		Title string `xml:"title"`
+		Link  string `xml:"link"`
+		GUID  string `xml:"guid"`
+	} `xml:"channel>item"`
+	Entries []struct {
+		Title string `xml:"title"`
+		ID    string `xml:"id"`
+		Links []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+		} `xml:"link"`
+	} `xml:"entry"`
+}
+
+// parseFeed reads items of RSS or Atom feed
+func parseFeed(data []byte) ([]newsItem, error) {
+	var doc feedDoc
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("bad feed: %w", err)
+	}
+	var items []newsItem
+	for _, it := range doc.Items {
+		item := newsItem{ID: strings.TrimSpace(it.GUID), Title: strings.TrimSpace(it.Title), Link: strings.TrimSpace(it.Link)}
+		if item.ID == "" {
+			item.ID = item.Link
+		}
+		items = append(items, item)
+	}
+	for _, e := range doc.Entries {
+		item := newsItem{ID: strings.TrimSpace(e.ID), Title: strings.TrimSpace(e.Title)}
+		for _, l := range e.Links {
+			if l.Rel == "" || l.Rel == "alternate" {
+				item.Link = l.Href
+				break
+			}
+		}
+		if item.ID == "" {
+			item.ID = item.Link
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// scrapeNews reads items matching CSS selector of HTML page. Text of
+// element is the title and its first link, or the element itself if it is
+// a link, identifies the item
+func scrapeNews(data []byte, base *url.URL, selector string) ([]newsItem, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("bad news page: %w", err)
+	}
+	var items []newsItem
+	doc.Find(selector).Each(func(i int, s *goquery.Selection) {
+		item := newsItem{Title: strings.Join(strings.Fields(s.Text()), " ")}
+		href, ok := s.Attr("href")
+		if !ok {
+			href, ok = s.Find("a[href]").First().Attr("href")
+		}
+		if ok {
+			if ref, err := url.Parse(href); err == nil {
+				item.Link = base.ResolveReference(ref).String()
+			}
+		}
+		item.ID = item.Link
+		if item.ID == "" {
+			item.ID = item.Title
+		}
+		items = append(items, item)
+	})
+	return items, nil
+}
+
+// unseenItems returns items newer than the one with lastID, oldest first
+func unseenItems(items []newsItem, lastID string) []newsItem {
+	var unseen []newsItem
+	for _, item := range items {
+		if item.ID == lastID {
+			break
+		}
+		unseen = append(unseen, item)
+	}
+	if len(unseen) > maxNewsItems {
+		unseen = unseen[:maxNewsItems]
+	}
+	for i, j := 0, len(unseen)-1; i < j; i, j = i+1, j-1 {
+		unseen[i], unseen[j] = unseen[j], unseen[i]
+	}
+	return unseen
+}
+
+// newsBatch is items not posted yet and the newest item of news page, to be
+// saved as last seen once items are posted
+type newsBatch struct {
+	Items    []newsItem
+	mark     string
+	latestID string
+}
+
+// seen saves the newest item of batch as last seen one
+func (b newsBatch) seen(ctx context.Context, dbstore storage.Storage) error {
+	return dbstore.SaveWatermark(ctx, b.mark, b.latestID)
+}
+
+// newsWatcher polls news page, RSS, Atom or HTML one if selector is set,
+// and passes new items to found. Last seen item is kept in storage once
+// they are posted, so nothing is posted twice after restart or on another
+// instance and nothing is lost if the bot stops in between
+type newsWatcher struct {
+	url      string
+	selector string
+	client   *http.Client
+	store    storage.Storage
+	leader   *leadership
+	found    chan newsBatch
+}
+
+// newNewsWatcher returns watcher of pageURL, nil if it is empty
+func newNewsWatcher(pageURL, selector string, store storage.Storage, leader *leadership) *newsWatcher {
+	if pageURL == "" {
+		return nil
+	}
+	return &newsWatcher{
+		url:      pageURL,
+		selector: selector,
+		client:   &http.Client{Timeout: newsTimeout},
+		store:    store,
+		leader:   leader,
+		found:    make(chan newsBatch),
+	}
+}
+
+// run checks news every interval while this instance is leader
+func (w *newsWatcher) run(shutdown context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if w.leader.IsLeader() {
+			ctx, cancel := context.WithTimeout(shutdown, newsTimeout+2*storageTimeout)
+			batch, err := w.check(ctx)
+			cancel()
+			if err != nil {
+				slog.Warn("news check failed", "url", w.url, "err", err)
+			} else if len(batch.Items) > 0 {
+				select {
+				case w.found <- batch:
+				case <-shutdown.Done():
+					return
+				}
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-shutdown.Done():
+			return
+		}
+	}
+}
+
+// check fetches news and returns items not seen before. The first check
+// only remembers the newest item, later ones leave it to the receiver of
+// the batch
+func (w *newsWatcher) check(ctx context.Context) (newsBatch, error) {
+	items, err := w.fetch(ctx)
+	if err != nil {
+		return newsBatch{}, err
+	}
+	if len(items) == 0 {
+		return newsBatch{}, errors.New("no news items found")
+	}
+	batch := newsBatch{mark: "news:" + w.url, latestID: items[0].ID}
+	lastID, err := w.store.GetWatermark(ctx, batch.mark)
+	first := errors.Is(err, storage.ErrNotFound)
+	if err != nil && !first {
+		return newsBatch{}, err
+	}
+	if items[0].ID == lastID {
+		return newsBatch{}, nil
+	}
+	if first {
+		if err = batch.seen(ctx, w.store); err != nil {
+			return newsBatch{}, err
+		}
+		slog.Info("news watcher started", "url", w.url, "latest", items[0].Title)
+		return newsBatch{}, nil
+	}
+	batch.Items = unseenItems(items, lastID)
+	return batch, nil
+}
+
+// fetch downloads news page and parses its items
+func (w *newsWatcher) fetch(ctx context.Context) ([]newsItem, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, w.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("news page: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxNewsPage))
+	if err != nil {
+		return nil, err
+	}
+	if w.selector != "" {
+		return scrapeNews(data, resp.Request.URL, w.selector)
+	}
+	return parseFeed(data)
+}
+
+// broadcastNews queues items to chats subscribed to news
+func broadcastNews(ctx context.Context, dbstore storage.Storage, out *outbox, items []newsItem) error {
+	chats, err := dbstore.GetNewsChats(ctx)
+	if err != nil {
+		return fmt.Errorf("can't get news subscribers: %w", err)
+	}
+	for _, item := range items {
+		text := "📰 " + item.Title
+		if item.Link != "" {
+			text += "\n" + item.Link
+		}
+		for _, chatID := range chats {
+			out.Send(chatID, tgbotapi.NewMessage(chatID, text))
+		}
+	}
+	logger(ctx).Info("news posted", "items", len(items), "chats", len(chats))
+	return nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+const testRSS = `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>News</title>
+<item><title> Update 42 </title><link>https://example.com/42</link><guid>n42</guid></item>
+<item><title>Update 41</title><link>https://example.com/41</link></item>
+</channel></rss>`
+
+const testAtom = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom"><title>News</title>
+<link rel="self" href="https://example.com/feed"/>
+<entry><title>Update 42</title><id>urn:42</id>
+<link rel="self" href="https://example.com/42.atom"/><link rel="alternate" href="https://example.com/42"/></entry>
+<entry><title>Update 41</title><link href="https://example.com/41"/></entry>
+</feed>`
+
+func TestParseFeed(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []newsItem
+		wantErr bool
+	}{
+		{
+			name: "rss",
+			data: testRSS,
+			want: []newsItem{
+				{ID: "n42", Title: "Update 42", Link: "https://example.com/42"},
+				{ID: "https://example.com/41", Title: "Update 41", Link: "https://example.com/41"},
+			},
+		},
+		{
+			name: "atom",
+			data: testAtom,
+			want: []newsItem{
+				{ID: "urn:42", Title: "Update 42", Link: "https://example.com/42"},
+				{ID: "https://example.com/41", Title: "Update 41", Link: "https://example.com/41"},
+			},
+		},
+		{name: "empty feed", data: `<rss><channel></channel></rss>`},
+		{name: "not xml", data: `<rss><channel><item>`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFeed([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScrapeNews(t *testing.T) {
+	page := `<html><body>
+<div class="news"><h2>Update  42
+ is out</h2>
+<a href="/news/42">more</a></div>
+<a class="news" href="41.html">Update 41</a>
+<div class="news">Maintenance tonight</div>
+<div class="other"><a href="/x">Not news</a></div>
+</body></html>`
+	base, _ := url.Parse("https://example.com/forum/")
+	got, err := scrapeNews([]byte(page), base, ".news")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []newsItem{
+		{ID: "https://example.com/news/42", Title: "Update 42 is out more", Link: "https://example.com/news/42"},
+		{ID: "https://example.com/forum/41.html", Title: "Update 41", Link: "https://example.com/forum/41.html"},
+		{ID: "Maintenance tonight", Title: "Maintenance tonight"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestUnseenItems(t *testing.T) {
+	items := []newsItem{{ID: "5"}, {ID: "4"}, {ID: "3"}, {ID: "2"}, {ID: "1"}}
+	tests := []struct {
+		lastID string
+		want   []newsItem
+	}{
+		{lastID: "5"},
+		{lastID: "4", want: []newsItem{{ID: "5"}}},
+		{lastID: "2", want: []newsItem{{ID: "3"}, {ID: "4"}, {ID: "5"}}},
+		// too many new items, only the newest are posted
+		{lastID: "1", want: []newsItem{{ID: "3"}, {ID: "4"}, {ID: "5"}}},
+		// last seen item is gone from the page
+		{lastID: "0", want: []newsItem{{ID: "3"}, {ID: "4"}, {ID: "5"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.lastID, func(t *testing.T) {
+			if got := unseenItems(items, tt.lastID); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// feedServer serves RSS with items of given IDs, newest first
+type feedServer struct {
+	mu  sync.Mutex
+	ids []string
+}
+
+func (f *feedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Write([]byte("<rss><channel>"))
+	for _, id := range f.ids {
+		w.Write([]byte("<item><title>Update " + id + "</title><guid>" + id + "</guid></item>"))
+	}
+	w.Write([]byte("</channel></rss>"))
+}
+
+func (f *feedServer) set(ids ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ids = ids
+}
+
+func TestNewsWatcherCheck(t *testing.T) {
+	ctx := context.Background()
+	feed := &feedServer{}
+	srv := httptest.NewServer(feed)
+	defer srv.Close()
+	store := newMemStore()
+	w := newNewsWatcher(srv.URL, "", store, nil)
+	mark := "news:" + srv.URL
+
+	feed.set()
+	if _, err := w.check(ctx); err == nil {
+		t.Error("empty feed: want error")
+	}
+
+	// the first check only remembers the newest item
+	feed.set("2", "1")
+	batch, err := w.check(ctx)
+	if err != nil || len(batch.Items) != 0 {
+		t.Fatalf("first check: got %+v, %v", batch, err)
+	}
+	if got, _ := store.GetWatermark(ctx, mark); got != "2" {
+		t.Fatalf("first check saved %q, want 2", got)
+	}
+
+	feed.set("4", "3", "2", "1")
+	batch, err = w.check(ctx)
+	if err != nil || !reflect.DeepEqual(batch.Items, []newsItem{{ID: "3", Title: "Update 3"}, {ID: "4", Title: "Update 4"}}) {
+		t.Fatalf("new items: got %+v, %v", batch, err)
+	}
+	// nothing is saved before the items are posted
+	if got, _ := store.GetWatermark(ctx, mark); got != "2" {
+		t.Errorf("check saved %q before posting", got)
+	}
+	if again, _ := w.check(ctx); !reflect.DeepEqual(again.Items, batch.Items) {
+		t.Errorf("unposted items: got %+v on next check, want them again", again.Items)
+	}
+	if err = batch.seen(ctx, store); err != nil {
+		t.Fatal(err)
+	}
+	if batch, err = w.check(ctx); err != nil || len(batch.Items) != 0 {
+		t.Errorf("after posting: got %+v, %v", batch, err)
+	}
+
+	// last seen item is gone from the page
+	feed.set("9", "8", "7", "6", "5")
+	batch, err = w.check(ctx)
+	if err != nil || len(batch.Items) != maxNewsItems || batch.Items[maxNewsItems-1].ID != "9" {
+		t.Fatalf("watermark not in page: got %+v, %v", batch, err)
+	}
+	if err = batch.seen(ctx, store); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := store.GetWatermark(ctx, mark); got != "9" {
+		t.Errorf("saved %q, want the newest item 9", got)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mementor/hafenbot/settings"
+	"github.com/mementor/hafenbot/storage"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+const (
+	// onlineHysteresis is percentage of threshold online has to move back
+	// past it before the alert is sent again, but at least minHysteresis
+	onlineHysteresis = 10
+	minHysteresis    = 5
+	// dropWindow is how far back drop of online is measured from its peak
+	dropWindow = 5 * time.Minute
+	// minDropPeak keeps drops of a nearly empty server quiet
+	minDropPeak = 20
+)
+
+type onlineSample struct {
+	at     time.Time
+	online int
+}
+
+// onlineAlerts tracks player count alerts of chats. An alert is sent when
+// its condition becomes met and is re-armed when online moves back past
+// hysteresis margin. Alerts seen for the first time are only recorded, so
+// neither restart nor changed threshold sends them for the current online
+type onlineAlerts struct {
+	// raised holds state of alerts by chat, kind and threshold
+	raised  map[string]bool
+	history []onlineSample
+}
+
+func newOnlineAlerts() *onlineAlerts {
+	return &onlineAlerts{raised: make(map[string]bool)}
+}
+
+// hysteresis returns margin of threshold
+func hysteresis(threshold int) int {
+	return max(threshold*onlineHysteresis/100, minHysteresis)
+}
+
+// evaluate records online seen at now and returns alert texts by chat
+func (a *onlineAlerts) evaluate(now time.Time, online int, chats []settings.Chat) map[int64][]string {
+	a.history = append(a.history, onlineSample{at: now, online: online})
+	for len(a.history) > 0 && now.Sub(a.history[0].at) > dropWindow {
+		a.history = a.history[1:]
+	}
+	peak := 0
+	var peakAt time.Time
+	for _, s := range a.history {
+		if s.online >= peak {
+			peak, peakAt = s.online, s.at
+		}
+	}
+	drop := 0
+	if peak >= minDropPeak {
+		drop = (peak - online) * 100 / peak
+	}
+
+	alerts := make(map[int64][]string)
+	raised := make(map[string]bool)
+	// cross keeps state of alert and tells whether it has just been raised
+	cross := func(key string, over, under bool) bool {
+		was, known := a.raised[key]
+		switch {
+		case over:
+			raised[key] = true
+			return known && !was
+		case under:
+			raised[key] = false
+		default:
+			raised[key] = was
+		}
+		return false
+	}
+	for _, c := range chats {
+		if n := c.OnlineAbove; n > 0 {
+			if cross(fmt.Sprintf("%d:above:%d", c.ChatID, n), online > n, online <= n-hysteresis(n)) {
+				alerts[c.ChatID] = append(alerts[c.ChatID], fmt.Sprintf("📈 %d players online, more than %d", online, n))
+			}
+		}
+		if n := c.OnlineBelow; n > 0 {
+			if cross(fmt.Sprintf("%d:below:%d", c.ChatID, n), online < n, online >= n+hysteresis(n)) {
+				alerts[c.ChatID] = append(alerts[c.ChatID], fmt.Sprintf("📉 %d players online, less than %d", online, n))
+			}
+		}
+		if n := c.OnlineDrop; n > 0 {
+			if cross(fmt.Sprintf("%d:drop:%d", c.ChatID, n), drop >= n, drop < n/2) {
+				alerts[c.ChatID] = append(alerts[c.ChatID], fmt.Sprintf("⚠️ players online fell by %d%% in %s: %d → %d, server may be about to crash",
+					drop, formatDuration(now.Sub(peakAt).Round(time.Second)), peak, online))
+			}
+		}
+	}
+	a.raised = raised
+	return alerts
+}
+
+// check updates player count alerts for state and sends raised ones to
+// chats if send is set. Followers call it without send to keep alert state
+// ready for failover
+func (a *onlineAlerts) check(out *outbox, chats []settings.Chat, state ServerState, send bool) {
+	online, ok := playersOnline(state.Online)
+	if !ok {
+		return
+	}
+	alerts := a.evaluate(time.Now(), online, chats)
+	if !send {
+		return
+	}
+	for chatID, texts := range alerts {
+		out.Send(chatID, tgbotapi.NewMessage(chatID, strings.Join(texts, "\n")))
+	}
+}
+
+// formatOnlineAlerts describes enabled player count alerts
+func formatOnlineAlerts(c *settings.Chat) string {
+	if !c.HasOnlineAlerts() {
+		return "No player count alerts here"
+	}
+	var reply bytes.Buffer
+	reply.WriteString("Player count alerts:\n")
+	if c.OnlineAbove > 0 {
+		reply.WriteString(fmt.Sprintf("📈 more than %d online\n", c.OnlineAbove))
+	}
+	if c.OnlineBelow > 0 {
+		reply.WriteString(fmt.Sprintf("📉 less than %d online\n", c.OnlineBelow))
+	}
+	if c.OnlineDrop > 0 {
+		reply.WriteString(fmt.Sprintf("⚠️ drop by %d%% within %s\n", c.OnlineDrop, formatDuration(dropWindow)))
+	}
+	return strings.TrimSuffix(reply.String(), "\n")
+}
+
+// handleOnlineAlert shows or changes player count alerts of chat:
+// /onlinealert [above N | below N | drop P | off [above|below|drop]]
+func handleOnlineAlert(ctx context.Context, bot *tgbotapi.BotAPI, dbstore storage.Storage, chatID int64, args string) {
+	c, err := getChatSettings(ctx, dbstore, chatID)
+	if err != nil {
+		logger(ctx).Error("can't get chat settings", "err", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "error: can't get settings, try again later"))
+		return
+	}
+	words := strings.Fields(strings.ToLower(args))
+	if len(words) == 0 {
+		bot.Send(tgbotapi.NewMessage(chatID, formatOnlineAlerts(c)+"\n\n/onlinealert above 500\n/onlinealert below 100\n/onlinealert drop 30\n/onlinealert off [above|below|drop]"))
+		return
+	}
+
+	switch {
+	case words[0] == "off" && len(words) == 1:
+		c.OnlineAbove, c.OnlineBelow, c.OnlineDrop = 0, 0, 0
+	case words[0] == "off" && len(words) == 2 && words[1] == "above":
+		c.OnlineAbove = 0
+	case words[0] == "off" && len(words) == 2 && words[1] == "below":
+		c.OnlineBelow = 0
+	case words[0] == "off" && len(words) == 2 && words[1] == "drop":
+		c.OnlineDrop = 0
+	case len(words) == 2 && (words[0] == "above" || words[0] == "below" || words[0] == "drop"):
+		n, err := strconv.Atoi(strings.TrimSuffix(words[1], "%"))
+		if err != nil || n <= 0 {
+			bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("error: bad number '%s'", words[1])))
+			return
+		}
+		switch words[0] {
+		case "above":
+			c.OnlineAbove = n
+		case "below":
+			c.OnlineBelow = n
+		case "drop":
+			if n >= 100 {
+				bot.Send(tgbotapi.NewMessage(chatID, "error: drop must be 1 to 99 percent"))
+				return
+			}
+			c.OnlineDrop = n
+		}
+	default:
+		bot.Send(tgbotapi.NewMessage(chatID, "usage: /onlinealert [above N | below N | drop P | off [above|below|drop]]"))
+		return
+	}
+	if err = dbstore.SaveChatSettings(ctx, c); err != nil {
+		logger(ctx).Error("can't save chat settings", "err", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "error: can't save settings, try again later"))
+		return
+	}
+	watchers.invalidate()
+	logger(ctx).Info("online alerts changed", "above", c.OnlineAbove, "below", c.OnlineBelow, "drop", c.OnlineDrop)
+	bot.Send(tgbotapi.NewMessage(chatID, "Done!\n"+formatOnlineAlerts(c)))
+}
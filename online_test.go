@@ -0,0 +1,118 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mementor/hafenbot/settings"
+)
+
+// onlineStep is online seen after given time from start and whether chat 1
+// gets an alert for it
+type onlineStep struct {
+	after  time.Duration
+	online int
+	alert  bool
+}
+
+func runOnlineSteps(t *testing.T, chat settings.Chat, steps []onlineStep) {
+	t.Helper()
+	chat.ChatID = 1
+	a := newOnlineAlerts()
+	start := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	for i, s := range steps {
+		got := a.evaluate(start.Add(s.after), s.online, []settings.Chat{chat})
+		if len(got[1]) > 0 != s.alert {
+			t.Errorf("step %d, %d online at +%s: got %q, want alert %v", i, s.online, s.after, got[1], s.alert)
+		}
+	}
+}
+
+func TestOnlineAbove(t *testing.T) {
+	// threshold 100, re-armed at 90 and below
+	runOnlineSteps(t, settings.Chat{OnlineAbove: 100}, []onlineStep{
+		{online: 50},
+		{online: 100},
+		{online: 101, alert: true},
+		{online: 150},
+		{online: 95},
+		{online: 120},
+		{online: 91},
+		{online: 101},
+		{online: 90},
+		{online: 101, alert: true},
+	})
+}
+
+func TestOnlineBelow(t *testing.T) {
+	// hysteresis of small thresholds is minHysteresis
+	runOnlineSteps(t, settings.Chat{OnlineBelow: 30}, []onlineStep{
+		{online: 40},
+		{online: 29, alert: true},
+		{online: 34},
+		{online: 10},
+		{online: 35},
+		{online: 29, alert: true},
+	})
+}
+
+func TestOnlineFirstSight(t *testing.T) {
+	// condition already met when alert is seen first, e.g. after restart
+	runOnlineSteps(t, settings.Chat{OnlineAbove: 100, OnlineBelow: 300}, []onlineStep{
+		{online: 150},
+		{online: 160},
+		{online: 80},
+		{online: 101, alert: true},
+	})
+
+	// changed threshold is a new alert
+	a := newOnlineAlerts()
+	now := time.Now()
+	a.evaluate(now, 50, []settings.Chat{{ChatID: 1, OnlineAbove: 100}})
+	if got := a.evaluate(now, 150, []settings.Chat{{ChatID: 1, OnlineAbove: 120}}); len(got) != 0 {
+		t.Errorf("changed threshold: got %v, want no alert", got)
+	}
+}
+
+func TestOnlineDrop(t *testing.T) {
+	runOnlineSteps(t, settings.Chat{OnlineDrop: 30}, []onlineStep{
+		{online: 100},
+		{after: time.Minute, online: 80},
+		{after: 2 * time.Minute, online: 60, alert: true},
+		{after: 3 * time.Minute, online: 40},
+	})
+}
+
+func TestOnlineDropSmallPeak(t *testing.T) {
+	runOnlineSteps(t, settings.Chat{OnlineDrop: 30}, []onlineStep{
+		{online: minDropPeak - 1},
+		{after: time.Minute, online: 0},
+		{after: 2 * time.Minute, online: minDropPeak},
+		{after: 3 * time.Minute, online: minDropPeak / 2, alert: true},
+	})
+}
+
+func TestOnlineDropWindow(t *testing.T) {
+	// peak older than dropWindow is forgotten, so slow decline is quiet
+	runOnlineSteps(t, settings.Chat{OnlineDrop: 30}, []onlineStep{
+		{online: 100},
+		{after: 3 * time.Minute, online: 80},
+		{after: dropWindow + time.Minute, online: 60},
+		{after: 2*dropWindow + 2*time.Minute, online: 45},
+	})
+
+	a := newOnlineAlerts()
+	start := time.Now()
+	chats := []settings.Chat{{ChatID: 1, OnlineDrop: 30}}
+	a.evaluate(start, 100, chats)
+	a.evaluate(start.Add(dropWindow), 100, chats)
+	a.evaluate(start.Add(dropWindow+time.Second), 90, chats)
+	if len(a.history) != 2 {
+		t.Errorf("history holds %d samples, want 2 within %s", len(a.history), dropWindow)
+	}
+	got := a.evaluate(start.Add(dropWindow+2*time.Second), 50, chats)
+	if len(got[1]) != 1 || !strings.Contains(got[1][0], "100 → 50") {
+		t.Errorf("got %q, want drop from the peak within window", got[1])
+	}
+}
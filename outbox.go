@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// outboxSize is number of messages queued before Send blocks
+const outboxSize = 1000
+
+type outboxMessage struct {
+	chatID int64
+	msg    tgbotapi.Chattable
+}
+
+// outbox sends messages in background so that fan-out to many chats does
+// not block the main loop. Queued messages are flushed on shutdown
+type outbox struct {
+	bot   *tgbotapi.BotAPI
+	queue chan outboxMessage
+	done  chan struct{}
+}
+
+func newOutbox(bot *tgbotapi.BotAPI) *outbox {
+	o := &outbox{
+		bot:   bot,
+		queue: make(chan outboxMessage, outboxSize),
+		done:  make(chan struct{}),
+	}
+	go o.run()
+	return o
+}
+
+// Send queues msg to chatID. Must not be called after Flush
+func (o *outbox) Send(chatID int64, msg tgbotapi.Chattable) {
+	o.queue <- outboxMessage{chatID: chatID, msg: msg}
+}
+
+func (o *outbox) run() {
+	defer close(o.done)
+	for m := range o.queue {
+		if _, err := o.bot.Send(m.msg); err != nil {
+			slog.Warn("can't send message", "chat_id", m.chatID, "err", err)
+		}
+	}
+}
+
+// Flush stops accepting messages and waits until queued ones are sent
+// or ctx is done. Returns number of messages left unsent
+func (o *outbox) Flush(ctx context.Context) int {
+	close(o.queue)
+	select {
+	case <-o.done:
+		return 0
+	case <-ctx.Done():
+		return len(o.queue)
+	}
+}
@@ -0,0 +1,38 @@
+package plot
+
+import "time"
+
+// Plot is a field sown with a crop. Every growth stage has a timer
+type Plot struct {
+	ChatID int64
+	// Name is lowercase and unique within chat
+	Name    string
+	Crop    string
+	Planted time.Time
+	Stages  []Stage
+	// TimerIDs are timers of the stages
+	TimerIDs []string
+}
+
+// Stage is a growth stage reached at At. The last one is harvest
+type Stage struct {
+	Name string
+	At   time.Time
+}
+
+// Current returns number of stages reached by now, 0 right after planting
+func (p *Plot) Current(now time.Time) int {
+	n := 0
+	for n < len(p.Stages) && !p.Stages[n].At.After(now) {
+		n++
+	}
+	return n
+}
+
+// Ready returns harvest time
+func (p *Plot) Ready() time.Time {
+	if len(p.Stages) == 0 {
+		return p.Planted
+	}
+	return p.Stages[len(p.Stages)-1].At
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mementor/hafenbot/metrics"
+	"github.com/mementor/hafenbot/storage"
+	"github.com/mementor/hafenbot/timer"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+	yaml "gopkg.in/yaml.v3"
+)
+
+//go:embed presets.yaml
+var defaultPresets []byte
+
+// presets is the catalog used by /preset, loaded on start
+var presets *presetCatalog
+
+// preset is a named timer with standard duration and text
+type preset struct {
+	Name        string   `yaml:"name"`
+	Aliases     []string `yaml:"aliases"`
+	Category    string   `yaml:"category"`
+	Duration    string   `yaml:"duration"`
+	Description string   `yaml:"description"`
+
+	dur time.Duration
+}
+
+type presetCatalog struct {
+	list []*preset
+	// byName holds presets by lowercase names and aliases
+	byName map[string]*preset
+}
+
+// loadPresets parses YAML list of presets and checks it
+func loadPresets(data []byte) (*presetCatalog, error) {
+	var list []*preset
+	if err := yaml.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("bad presets: %w", err)
+	}
+	catalog := &presetCatalog{list: list, byName: make(map[string]*preset)}
+	for i, p := range list {
+		if p.Name == "" {
+			return nil, fmt.Errorf("bad presets: #%d has no name", i+1)
+		}
+		if p.Description == "" {
+			return nil, fmt.Errorf("bad presets: '%s' has no description", p.Name)
+		}
+		dur, err := parseDuration(p.Duration)
+		if err != nil {
+			return nil, fmt.Errorf("bad presets: '%s' has bad duration '%s'", p.Name, p.Duration)
+		}
+		p.dur = dur
+		for _, name := range append([]string{p.Name}, p.Aliases...) {
+			name = strings.ToLower(name)
+			if other, ok := catalog.byName[name]; ok {
+				return nil, fmt.Errorf("bad presets: '%s' is used by '%s' and '%s'", name, other.Name, p.Name)
+			}
+			catalog.byName[name] = p
+		}
+	}
+	if len(list) == 0 {
+		return nil, errors.New("bad presets: no presets")
+	}
+	return catalog, nil
+}
+
+// find returns preset by name or alias, or by unique prefix of them
+func (c *presetCatalog) find(name string) (*preset, error) {
+	name = strings.ToLower(name)
+	if p, ok := c.byName[name]; ok {
+		return p, nil
+	}
+	var found *preset
+	for key, p := range c.byName {
+		if !strings.HasPrefix(key, name) {
+			continue
+		}
+		if found != nil && found != p {
+			return nil, fmt.Errorf("'%s' matches several presets, be more specific", name)
+		}
+		found = p
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no preset '%s', /presets lists them", name)
+	}
+	return found, nil
+}
+
+// handlePreset creates timer from preset: /preset <name> [note]
+func handlePreset(ctx context.Context, bot *tgbotapi.BotAPI, dbstore storage.Storage, reload chan bool, msg *tgbotapi.Message, args string) {
+	chatID := msg.Chat.ID
+	name, note, _ := strings.Cut(strings.TrimSpace(args), " ")
+	if name == "" {
+		bot.Send(tgbotapi.NewMessage(chatID, "usage: /preset <name> [note], e.g. /p cheese rack 3\n/presets lists them"))
+		return
+	}
+	p, err := presets.find(name)
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("error: %s", err)))
+		return
+	}
+
+	body := p.Description
+	if note = strings.TrimSpace(note); note != "" {
+		body = fmt.Sprintf("%s (%s)", body, note)
+	}
+	t := &timer.Timer{
+		At:          time.Now().Add(p.dur),
+		Body:        body,
+		ChatID:      chatID,
+		CreatorID:   msg.From.ID,
+		CreatorName: userName(msg.From),
+	}
+	if err = validateTimer(t.At, t.Body, time.Now()); err != nil {
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("error: %s", err)))
+		return
+	}
+	if err = dbstore.SaveTimer(ctx, t); err != nil {
+		logger(ctx).Error("can't save timer", "err", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "error: can't save timer, try again later"))
+		return
+	}
+	logger(ctx).Info("timer created", "timer_id", t.ID, "at", t.At, "preset", p.Name)
+	metrics.TimersCreated.WithLabelValues("preset").Inc()
+	reload <- true
+	reply := fmt.Sprintf("⏲ fire at %s\n%s", t.At.In(location).Format("2006-01-02 15:04:05 MST"), t.Body)
+	bot.Send(tgbotapi.NewMessage(chatID, reply))
+}
+
+// handlePresets lists presets grouped by category
+func handlePresets(bot *tgbotapi.BotAPI, chatID int64) {
+	byCategory := make(map[string][]*preset)
+	var categories []string
+	for _, p := range presets.list {
+		if _, ok := byCategory[p.Category]; !ok {
+			categories = append(categories, p.Category)
+		}
+		byCategory[p.Category] = append(byCategory[p.Category], p)
+	}
+	sort.Strings(categories)
+
+	var reply bytes.Buffer
+	for _, category := range categories {
+		if category != "" {
+			reply.WriteString(fmt.Sprintf("%s:\n", category))
+		}
+		for _, p := range byCategory[category] {
+			names := p.Name
+			if len(p.Aliases) > 0 {
+				names += " (" + strings.Join(p.Aliases, ", ") + ")"
+			}
+			reply.WriteString(fmt.Sprintf("  %s %s: %s\n", names, formatDuration(p.dur), p.Description))
+		}
+	}
+	reply.WriteString("\n/p <name> [note] starts a timer")
+	bot.Send(tgbotapi.NewMessage(chatID, reply.String()))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadDefaultPresets(t *testing.T) {
+	catalog, err := loadPresets(defaultPresets)
+	if err != nil {
+		t.Fatalf("embedded presets: %v", err)
+	}
+	for _, p := range catalog.list {
+		if p.dur <= 0 {
+			t.Errorf("'%s' has duration %v", p.Name, p.dur)
+		}
+	}
+}
+
+func TestLoadPresetsRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{
+			name:    "duplicate alias",
+			yaml:    "- {name: tan, aliases: [tub], duration: 1d, description: x}\n- {name: dye, aliases: [TUB], duration: 1h, description: y}\n",
+			wantErr: "'tub' is used by 'tan' and 'dye'",
+		},
+		{
+			name:    "alias repeats name",
+			yaml:    "- {name: tan, duration: 1d, description: x}\n- {name: dye, aliases: [tan], duration: 1h, description: y}\n",
+			wantErr: "'tan' is used by",
+		},
+		{name: "bad duration", yaml: "- {name: tan, duration: a day, description: x}\n", wantErr: "bad duration 'a day'"},
+		{name: "no duration", yaml: "- {name: tan, description: x}\n", wantErr: "bad duration ''"},
+		{name: "no name", yaml: "- {duration: 1d, description: x}\n", wantErr: "#1 has no name"},
+		{name: "no description", yaml: "- {name: tan, duration: 1d}\n", wantErr: "'tan' has no description"},
+		{name: "empty", yaml: "", wantErr: "no presets"},
+		{name: "not a list", yaml: "name: tan\n", wantErr: "bad presets"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadPresets([]byte(tt.yaml))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPresetFind(t *testing.T) {
+	catalog, err := loadPresets([]byte(`
+- {name: cheese, aliases: [rack], duration: 1d, description: x}
+- {name: cheesecellar, aliases: [cellar], duration: 2d, description: y}
+- {name: tan, aliases: [tanning, tub], duration: 1d, description: z}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		want    string
+		wantErr string
+	}{
+		{name: "cheese", want: "cheese"},
+		{name: "Rack", want: "cheese"},
+		{name: "cellar", want: "cheesecellar"},
+		// exact name wins over longer names with it as prefix
+		{name: "tan", want: "tan"},
+		{name: "cel", want: "cheesecellar"},
+		{name: "cheesec", want: "cheesecellar"},
+		// prefix of several keys of the same preset is still unique
+		{name: "tann", want: "tan"},
+		{name: "t", want: "tan"},
+		{name: "chee", wantErr: "matches several presets"},
+		{name: "c", wantErr: "matches several presets"},
+		{name: "dye", wantErr: "no preset 'dye'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := catalog.find(tt.name)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Name != tt.want {
+				t.Errorf("got '%s', want '%s'", p.Name, tt.want)
+			}
+		})
+	}
+}
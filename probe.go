@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mementor/hafenbot/metrics"
+)
+
+const (
+	// probeTimeout limits TCP connect of game server probe
+	probeTimeout = 5 * time.Second
+	// probeFailures consecutive failed probes make game server unreachable,
+	// a single lost connect is not an outage
+	probeFailures = 2
+)
+
+// probeResult is outcome of TCP connect to game server
+type probeResult struct {
+	At      time.Time
+	Latency time.Duration
+	Err     error
+}
+
+// probeTCP connects to addr and measures how long it takes
+func probeTCP(ctx context.Context, addr string) probeResult {
+	var d net.Dialer
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	start := time.Now()
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	res := probeResult{At: start, Latency: time.Since(start), Err: err}
+	if err == nil {
+		conn.Close()
+	}
+	return res
+}
+
+// gameProbe checks that game server accepts connections. The portal page
+// lags behind real outages, so both are combined in verdict
+type gameProbe struct {
+	addr string
+
+	mu    sync.Mutex
+	last  probeResult
+	fails int
+}
+
+// newGameProbe returns probe of addr, nil if addr is empty
+func newGameProbe(addr string) *gameProbe {
+	if addr == "" {
+		return nil
+	}
+	return &gameProbe{addr: addr}
+}
+
+// check probes game server once and records the result
+func (p *gameProbe) check(ctx context.Context) {
+	res := probeTCP(ctx, p.addr)
+
+	p.mu.Lock()
+	wasReachable := p.reachable()
+	known := !p.last.At.IsZero()
+	p.last = res
+	if res.Err != nil {
+		p.fails++
+	} else {
+		p.fails = 0
+	}
+	reachable := p.reachable()
+	p.mu.Unlock()
+
+	if res.Err != nil {
+		metrics.GameProbes.WithLabelValues("failure").Inc()
+		slog.Debug("game server probe failed", "addr", p.addr, "err", res.Err)
+	} else {
+		metrics.GameProbes.WithLabelValues("success").Inc()
+		metrics.GameProbeLatency.Observe(res.Latency.Seconds())
+	}
+	if reachable {
+		metrics.GameReachable.Set(1)
+	} else {
+		metrics.GameReachable.Set(0)
+	}
+	if known && reachable != wasReachable {
+		slog.Info("game server reachability changed", "addr", p.addr, "reachable", reachable, "err", res.Err)
+	}
+}
+
+// reachable tells whether recent probes succeeded. Must be called with mu held
+func (p *gameProbe) reachable() bool {
+	return p.fails < probeFailures
+}
+
+// verdict combines portal status with probes into server health
+func (p *gameProbe) verdict(status string) string {
+	portalUp := serverUp(status)
+	if p == nil {
+		if portalUp {
+			return "✅ up"
+		}
+		return "❌ down"
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.last.At.IsZero() {
+		return "❔ game server is not probed yet"
+	}
+	reachable := p.reachable()
+	probe := fmt.Sprintf("connect %s", p.last.Latency.Round(time.Millisecond))
+	if p.last.Err != nil {
+		probe = fmt.Sprintf("%d failed connects in a row", p.fails)
+	}
+	switch {
+	case portalUp && reachable:
+		return "✅ up, " + probe
+	case portalUp:
+		return "⚠️ portal says up, but game server is unreachable: " + probe
+	case reachable:
+		return "🔄 portal says down, but game server accepts connections: " + probe
+	}
+	return "❌ down, " + probe
+}
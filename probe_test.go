@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestGameProbeVerdict(t *testing.T) {
+	const (
+		up   = "The server is up"
+		down = "The server is down"
+	)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	ctx := context.Background()
+	reachable := newGameProbe(addr)
+	reachable.check(ctx)
+	if reachable.last.Err != nil {
+		t.Fatalf("probe of listener failed: %s", reachable.last.Err)
+	}
+
+	notProbed := newGameProbe(addr)
+
+	ln.Close()
+	unreachable := newGameProbe(addr)
+	for i := 0; i < probeFailures; i++ {
+		unreachable.check(ctx)
+	}
+	if unreachable.last.Err == nil {
+		t.Fatal("probe of closed listener succeeded")
+	}
+	// a single failure after success is not an outage yet
+	flaky := newGameProbe(addr)
+	flaky.check(ctx)
+
+	tests := []struct {
+		name   string
+		probe  *gameProbe
+		status string
+		want   string
+	}{
+		{"portal only up", nil, up, "✅ up"},
+		{"portal only down", nil, down, "❌ down"},
+		{"not probed yet", notProbed, up, "❔ game server is not probed yet"},
+		{"both up", reachable, up, "✅ up, connect "},
+		{"portal up, probe down", unreachable, up, "⚠️ portal says up, but game server is unreachable: 2 failed connects in a row"},
+		{"portal down, probe up", reachable, down, "🔄 portal says down, but game server accepts connections: connect "},
+		{"both down", unreachable, down, "❌ down, 2 failed connects in a row"},
+		{"single failure", flaky, up, "✅ up, 1 failed connects in a row"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.probe.verdict(tt.status)
+			if !strings.HasPrefix(got, tt.want) {
+				t.Errorf("verdict = %q, want prefix %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewGameProbeDisabled(t *testing.T) {
+	if p := newGameProbe(""); p != nil {
+		t.Errorf("newGameProbe(\"\") = %+v, want nil", p)
+	}
+}
@@ -0,0 +1,25 @@
+package settings
+
+// Chat holds settings of server notifications of a chat
+type Chat struct {
+	ChatID int64
+	// OnlineAbove and OnlineBelow are player counts the chat is alerted at
+	// when online crosses them. Zero disables the alert
+	OnlineAbove int
+	OnlineBelow int
+	// OnlineDrop is percentage of players lost within minutes the chat is
+	// alerted at, such drops often precede a crash. Zero disables the alert
+	OnlineDrop int
+	// TimeZone is IANA name of zone times are shown in, empty means
+	// default zone of the bot
+	TimeZone string
+	// StatusTemplate is template of server status change notices with
+	// placeholders like {new} and {online},
+	// empty means the default one
+	StatusTemplate string
+}
+
+// HasOnlineAlerts tells whether any player count alert is enabled
+func (c *Chat) HasOnlineAlerts() bool {
+	return c.OnlineAbove > 0 || c.OnlineBelow > 0 || c.OnlineDrop > 0
+}
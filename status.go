@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	// zones for /timezone on hosts without zoneinfo
+	_ "time/tzdata"
+
+	"github.com/mementor/hafenbot/settings"
+	"github.com/mementor/hafenbot/storage"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+const (
+	// flapChanges status changes within flapWindow make server unstable.
+	// It is stable again once status holds for flapWindow
+	flapChanges = 3
+	flapWindow  = 5 * time.Minute
+	// maxStatusTemplate limits length of chat status template
+	maxStatusTemplate = 1000
+)
+
+// defaultStatusTemplate is used by chats without their own template
+const defaultStatusTemplate = `'{old}'
+=>
+'{new}'
+⏱ previous status lasted {lasted}
+🔧 downtime {downtime}
+👥 {online}
+🕐 {at}`
+
+// statusFieldRe matches placeholders of status template
+var statusFieldRe = regexp.MustCompile(`\{[a-z]+\}`)
+
+// legacyStatusFields rewrites fields of templates saved in text/template
+// syntax, other actions of it are not supported anymore
+var legacyStatusFields = strings.NewReplacer(
+	"{{.Old}}", "{old}", "{{.New}}", "{new}", "{{.Lasted}}", "{lasted}",
+	"{{.Downtime}}", "{downtime}", "{{.Online}}", "{online}", "{{.At}}", "{at}",
+)
+
+// statusChange is data of status change notices
+type statusChange struct {
+	Old string
+	New string
+	// Lasted is how long Old status lasted, empty if it is unknown
+	Lasted string
+	// Downtime is set when server comes back up
+	Downtime string
+	Online   string
+	// At is time of the change in zone of chat
+	At string
+
+	at time.Time
+}
+
+// statusClock remembers when current status began and when server went down
+type statusClock struct {
+	since     time.Time
+	downSince time.Time
+}
+
+// change records status change at now and returns data of its notice
+func (c *statusClock) change(now time.Time, old string, cur ServerState) statusChange {
+	ev := statusChange{Old: old, New: cur.Status, Online: cur.Online, at: now}
+	if !c.since.IsZero() {
+		ev.Lasted = formatDuration(now.Sub(c.since).Round(time.Second))
+	}
+	switch {
+	case serverUp(cur.Status) && !c.downSince.IsZero():
+		ev.Downtime = formatDuration(now.Sub(c.downSince).Round(time.Second))
+		c.downSince = time.Time{}
+	case !serverUp(cur.Status) && serverUp(old):
+		c.downSince = now
+	}
+	c.since = now
+	return ev
+}
+
+// statusFlaps aggregates rapid status changes, so that subscribers get one
+// notice about unstable server instead of every flip
+type statusFlaps struct {
+	changes []time.Time
+	// unstableSince is time of the first flip, zero while server is stable
+	unstableSince time.Time
+}
+
+// change records status change at now. It tells whether the change is to
+// be reported and returns notice when the change makes server unstable
+func (f *statusFlaps) change(now time.Time, cur string) (report bool, notice string) {
+	f.changes = append(f.changes, now)
+	for len(f.changes) > 0 && now.Sub(f.changes[0]) > flapWindow {
+		f.changes = f.changes[1:]
+	}
+	if !f.unstableSince.IsZero() {
+		return false, ""
+	}
+	if len(f.changes) >= flapChanges {
+		f.unstableSince = f.changes[0]
+		return true, fmt.Sprintf("⚠️ server is unstable for %s, status changed %d times\nnow: '%s'",
+			formatDuration(now.Sub(f.unstableSince).Round(time.Second)), len(f.changes), cur)
+	}
+	return true, ""
+}
+
+// settle returns notice to broadcast when unstable server has held its
+// status for flapWindow
+func (f *statusFlaps) settle(now time.Time, cur string) (string, bool) {
+	if f.unstableSince.IsZero() || len(f.changes) == 0 || now.Sub(f.changes[len(f.changes)-1]) < flapWindow {
+		return "", false
+	}
+	last := f.changes[len(f.changes)-1]
+	text := fmt.Sprintf("✅ server is stable again after being unstable for %s\nnow: '%s'",
+		formatDuration(last.Sub(f.unstableSince).Round(time.Second)), cur)
+	f.changes, f.unstableSince = nil, time.Time{}
+	return text, true
+}
+
+// broadcastStatus sends text to chats subscribed to server status
+func broadcastStatus(ctx context.Context, dbstore storage.Storage, out *outbox, text string) {
+	chats, err := dbstore.GetSSChats(ctx)
+	if err != nil {
+		logger(ctx).Error("can't get status subscribers", "err", err)
+	}
+	for _, chatID := range chats {
+		out.Send(chatID, tgbotapi.NewMessage(chatID, text))
+	}
+}
+
+// chatLocation returns zone of chat, bot default if chat has none
+func chatLocation(c *settings.Chat) *time.Location {
+	if c == nil || c.TimeZone == "" {
+		return location
+	}
+	loc, err := time.LoadLocation(c.TimeZone)
+	if err != nil {
+		return location
+	}
+	return loc
+}
+
+// renderStatus fills status template for event in zone loc. Lines whose
+// placeholders are all empty, like {downtime} while server goes down, are
+// left out
+func renderStatus(tmpl string, ev statusChange, loc *time.Location) (string, error) {
+	if tmpl == "" {
+		tmpl = defaultStatusTemplate
+	}
+	tmpl = legacyStatusFields.Replace(tmpl)
+	if strings.Contains(tmpl, "{{") {
+		return "", errors.New("{{ }} actions are not supported, use {old} {new} {lasted} {downtime} {online} {at}")
+	}
+	fields := map[string]string{
+		"{old}":      ev.Old,
+		"{new}":      ev.New,
+		"{lasted}":   ev.Lasted,
+		"{downtime}": ev.Downtime,
+		"{online}":   ev.Online,
+		"{at}":       ev.at.In(loc).Format("2006-01-02 15:04:05 MST"),
+	}
+	pairs := make([]string, 0, 2*len(fields))
+	for field, value := range fields {
+		pairs = append(pairs, field, value)
+	}
+	fill := strings.NewReplacer(pairs...)
+
+	var lines []string
+	for _, line := range strings.Split(tmpl, "\n") {
+		used := statusFieldRe.FindAllString(line, -1)
+		empty := len(used) > 0
+		for _, field := range used {
+			value, ok := fields[field]
+			if !ok {
+				return "", fmt.Errorf("unknown field %s", field)
+			}
+			if value != "" {
+				empty = false
+			}
+		}
+		if !empty {
+			lines = append(lines, fill.Replace(line))
+		}
+	}
+	text := strings.Join(lines, "\n")
+	if strings.TrimSpace(text) == "" {
+		return "", errors.New("template gives empty text")
+	}
+	return text, nil
+}
+
+// broadcastChange sends status change notice to subscribed chats, each in
+// its own template and zone
+func broadcastChange(ctx context.Context, dbstore storage.Storage, out *outbox, ev statusChange) {
+	chats, err := dbstore.GetSSChats(ctx)
+	if err != nil {
+		logger(ctx).Error("can't get status subscribers", "err", err)
+		return
+	}
+	list, err := dbstore.ListChatSettings(ctx)
+	if err != nil {
+		logger(ctx).Warn("can't list chat settings, using defaults", "err", err)
+	}
+	byChat := make(map[int64]*settings.Chat)
+	for i := range list {
+		byChat[list[i].ChatID] = &list[i]
+	}
+	for _, chatID := range chats {
+		var tmpl string
+		if c := byChat[chatID]; c != nil {
+			tmpl = c.StatusTemplate
+		}
+		text, err := renderStatus(tmpl, ev, chatLocation(byChat[chatID]))
+		if err != nil {
+			logger(ctx).Warn("bad status template, using default", "chat_id", chatID, "err", err)
+			text, _ = renderStatus("", ev, chatLocation(byChat[chatID]))
+		}
+		out.Send(chatID, tgbotapi.NewMessage(chatID, text))
+	}
+}
+
+// getChatSettings returns settings of chat, empty ones if it has none
+func getChatSettings(ctx context.Context, dbstore storage.Storage, chatID int64) (*settings.Chat, error) {
+	c, err := dbstore.GetChatSettings(ctx, chatID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return &settings.Chat{ChatID: chatID}, nil
+	}
+	return c, err
+}
+
+// handleTimeZone shows or sets zone of server status notices:
+// /timezone [zone | reset]
+func handleTimeZone(ctx context.Context, bot *tgbotapi.BotAPI, dbstore storage.Storage, chatID int64, args string) {
+	c, err := getChatSettings(ctx, dbstore, chatID)
+	if err != nil {
+		logger(ctx).Error("can't get chat settings", "err", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "error: can't get settings, try again later"))
+		return
+	}
+	args = strings.TrimSpace(args)
+	if args == "" {
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Time zone of status notices: %s\n/timezone Europe/Berlin to change it\n/timezone reset for the default", chatLocation(c))))
+		return
+	}
+	if args == "reset" {
+		c.TimeZone = ""
+	} else {
+		loc, err := time.LoadLocation(args)
+		if err != nil || args == "Local" {
+			bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("error: unknown time zone '%s', use names like Europe/Berlin or UTC", args)))
+			return
+		}
+		c.TimeZone = loc.String()
+	}
+	if err = dbstore.SaveChatSettings(ctx, c); err != nil {
+		logger(ctx).Error("can't save chat settings", "err", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "error: can't save settings, try again later"))
+		return
+	}
+	logger(ctx).Info("time zone changed", "zone", c.TimeZone)
+	bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Done! Status notices use %s", chatLocation(c))))
+}
+
+// handleStatusFormat shows or sets template of status change notices:
+// /statusformat [template | reset]
+func handleStatusFormat(ctx context.Context, bot *tgbotapi.BotAPI, dbstore storage.Storage, ss *ServerStatus, chatID int64, args string) {
+	c, err := getChatSettings(ctx, dbstore, chatID)
+	if err != nil {
+		logger(ctx).Error("can't get chat settings", "err", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "error: can't get settings, try again later"))
+		return
+	}
+	state := ss.State()
+	sample := statusChange{
+		Old:      "The server is down",
+		New:      state.Status,
+		Lasted:   "12m",
+		Downtime: "12m",
+		Online:   state.Online,
+		at:       time.Now(),
+	}
+	args = strings.TrimSpace(args)
+	if args == "" {
+		tmpl := c.StatusTemplate
+		if tmpl == "" {
+			tmpl = defaultStatusTemplate
+		}
+		example, _ := renderStatus(c.StatusTemplate, sample, chatLocation(c))
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("Status notice template:\n%s\n\nlooks like:\n%s\n\nFields: {old} {new} {lasted} {downtime} {online} {at}, lines with only empty fields are left out\n/statusformat <template> to change it\n/statusformat reset for the default", tmpl, example)))
+		return
+	}
+	if args == "reset" {
+		c.StatusTemplate = ""
+	} else {
+		if len(args) > maxStatusTemplate {
+			bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("error: template is longer than %d characters", maxStatusTemplate)))
+			return
+		}
+		if _, err = renderStatus(args, sample, chatLocation(c)); err != nil {
+			bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("error: bad template: %s", err)))
+			return
+		}
+		c.StatusTemplate = args
+	}
+	if err = dbstore.SaveChatSettings(ctx, c); err != nil {
+		logger(ctx).Error("can't save chat settings", "err", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "error: can't save settings, try again later"))
+		return
+	}
+	logger(ctx).Info("status template changed", "custom", c.StatusTemplate != "")
+	example, _ := renderStatus(c.StatusTemplate, sample, chatLocation(c))
+	bot.Send(tgbotapi.NewMessage(chatID, "Done! Status notices look like:\n"+example))
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerStatusObserve(t *testing.T) {
+	const (
+		up   = "The server is up"
+		down = "The server is down"
+	)
+	type scrape struct {
+		status  string
+		online  string
+		want    string
+		changed bool
+	}
+	tests := []struct {
+		name    string
+		confirm int
+		scrapes []scrape
+	}{
+		{"first scrape is not a change", 2, []scrape{
+			{up, "10 players", up, false},
+		}},
+		{"glitch is ignored", 2, []scrape{
+			{up, "10 players", up, false},
+			{down, "10 players", up, false},
+			{up, "10 players", up, false},
+			{down, "10 players", up, false},
+		}},
+		{"confirmed change", 2, []scrape{
+			{up, "10 players", up, false},
+			{down, "10 players", up, false},
+			{down, "10 players", down, true},
+			{down, "10 players", down, false},
+		}},
+		{"no debounce", 1, []scrape{
+			{up, "10 players", up, false},
+			{down, "10 players", down, true},
+		}},
+		{"other candidate restarts count", 3, []scrape{
+			{up, "", up, false},
+			{down, "", up, false},
+			{"Restarting", "", up, false},
+			{down, "", up, false},
+			{down, "", up, false},
+			{down, "", down, true},
+		}},
+		{"online change is reported at once", 2, []scrape{
+			{up, "10 players", up, false},
+			{up, "12 players", up, true},
+			{up, "", up, true},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ss := &ServerStatus{Confirm: tt.confirm}
+			for i, sc := range tt.scrapes {
+				before := ss.State()
+				change, changed := ss.observe(sc.status, sc.online)
+				if changed != sc.changed {
+					t.Errorf("scrape %d: changed = %v, want %v", i, changed, sc.changed)
+				}
+				if ss.State().Status != sc.want {
+					t.Errorf("scrape %d: status %q, want %q", i, ss.State().Status, sc.want)
+				}
+				if changed && (change.Old != before || change.New != ss.State()) {
+					t.Errorf("scrape %d: change %+v, want %+v -> %+v", i, change, before, ss.State())
+				}
+			}
+			if online := ss.State().Online; tt.scrapes[len(tt.scrapes)-1].online == "" && online != "unknown" {
+				t.Errorf("online %q, want unknown", online)
+			}
+		})
+	}
+}
+
+func TestRenderStatus(t *testing.T) {
+	at := time.Date(2026, 10, 19, 9, 30, 0, 0, time.UTC)
+	ev := statusChange{Old: "The server is down", New: "The server is up", Lasted: "12m", Downtime: "12m", Online: "42 players", at: at}
+	goingDown := statusChange{Old: "The server is up", New: "The server is down", Online: "0 players", at: at}
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		tmpl    string
+		ev      statusChange
+		loc     *time.Location
+		want    string
+		wantErr string
+	}{
+		{
+			name: "default",
+			ev:   ev,
+			loc:  location,
+			want: "'The server is down'\n=>\n'The server is up'\n⏱ previous status lasted 12m\n🔧 downtime 12m\n👥 42 players\n🕐 2026-10-19 12:30:00 MSK",
+		},
+		{
+			name: "default skips empty lines",
+			ev:   goingDown,
+			loc:  location,
+			want: "'The server is up'\n=>\n'The server is down'\n👥 0 players\n🕐 2026-10-19 12:30:00 MSK",
+		},
+		{name: "custom in chat zone", tmpl: "{new} at {at}", ev: ev, loc: berlin, want: "The server is up at 2026-10-19 11:30:00 CEST"},
+		{name: "line with some empty fields is kept", tmpl: "{new} {downtime}", ev: goingDown, loc: location, want: "The server is down "},
+		{name: "no fields", tmpl: "status changed", ev: ev, loc: location, want: "status changed"},
+		{name: "values are not expanded", tmpl: "{new}", ev: statusChange{New: "{old}", Old: "x"}, loc: location, want: "{old}"},
+		{name: "legacy fields", tmpl: "{{.New}} ({{.Online}})", ev: ev, loc: location, want: "The server is up (42 players)"},
+		{name: "legacy actions", tmpl: "{{range 1000000}}{{.New}}{{end}}", ev: ev, loc: location, wantErr: "not supported"},
+		{name: "unknown field", tmpl: "{new} {players}", ev: ev, loc: location, wantErr: "unknown field {players}"},
+		{name: "empty", tmpl: "{downtime}", ev: goingDown, loc: location, wantErr: "empty text"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderStatus(tt.tmpl, tt.ev, tt.loc)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatusClock(t *testing.T) {
+	const (
+		up   = "The server is up"
+		down = "The server is down"
+	)
+	start := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	var c statusClock
+
+	ev := c.change(start, up, ServerState{Status: down, Online: "0 players"})
+	if ev.Lasted != "" || ev.Downtime != "" || ev.Old != up || ev.New != down || ev.Online != "0 players" {
+		t.Errorf("first change: got %+v, want no durations", ev)
+	}
+	// restarting is still down
+	ev = c.change(start.Add(5*time.Minute), down, ServerState{Status: "Restarting"})
+	if ev.Lasted != "5m" || ev.Downtime != "" {
+		t.Errorf("down to restarting: got %+v", ev)
+	}
+	ev = c.change(start.Add(12*time.Minute), "Restarting", ServerState{Status: up})
+	if ev.Lasted != "7m" || ev.Downtime != "12m" {
+		t.Errorf("back up: got %+v, want downtime since the first change", ev)
+	}
+	ev = c.change(start.Add(2*time.Hour+12*time.Minute), up, ServerState{Status: "The server is up, 2 players"})
+	if ev.Lasted != "2h" || ev.Downtime != "" {
+		t.Errorf("up to up: got %+v, want no downtime", ev)
+	}
+}
+
+func TestStatusFlaps(t *testing.T) {
+	start := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	var f statusFlaps
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+
+	if report, notice := f.change(at(0), "down"); !report || notice != "" {
+		t.Fatalf("first change: report %v, notice %q", report, notice)
+	}
+	if report, notice := f.change(at(time.Minute), "up"); !report || notice != "" {
+		t.Fatalf("second change: report %v, notice %q", report, notice)
+	}
+	if _, ok := f.settle(at(2*time.Minute), "up"); ok {
+		t.Fatal("stable server settled")
+	}
+	report, notice := f.change(at(2*time.Minute), "down")
+	if !report || !strings.Contains(notice, "unstable for 2m") || !strings.Contains(notice, "3 times") {
+		t.Fatalf("third change: report %v, notice %q", report, notice)
+	}
+	// later flips are quiet
+	for i := 3; i < 6; i++ {
+		if report, _ = f.change(at(time.Duration(i)*time.Minute), "up"); report {
+			t.Fatalf("change %d reported while unstable", i)
+		}
+	}
+	if _, ok := f.settle(at(5*time.Minute+flapWindow-time.Second), "up"); ok {
+		t.Fatal("settled before status held for flapWindow")
+	}
+	notice, ok := f.settle(at(5*time.Minute+flapWindow), "up")
+	if !ok || !strings.Contains(notice, "unstable for 5m") || !strings.Contains(notice, "'up'") {
+		t.Fatalf("settle: ok %v, notice %q", ok, notice)
+	}
+	if _, ok = f.settle(at(time.Hour), "up"); ok {
+		t.Error("settled twice")
+	}
+
+	// changes further apart than flapWindow never make server unstable
+	f = statusFlaps{}
+	for i := 0; i < 5; i++ {
+		if report, notice = f.change(at(time.Duration(i)*(flapWindow/2+time.Second)), "x"); !report || notice != "" {
+			t.Errorf("slow change %d: report %v, notice %q", i, report, notice)
+		}
+	}
+}
@@ -1,26 +1,48 @@
 package dynamodb
 
 import (
-	"errors"
+	"context"
 	"fmt"
-	"log"
+	"sort"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/mementor/hafenbot/plot"
+	"github.com/mementor/hafenbot/settings"
 	"github.com/mementor/hafenbot/storage"
 	"github.com/mementor/hafenbot/timer"
 	uuid "github.com/satori/go.uuid"
 )
 
+const (
+	serviceTable = "HafenTable"
+	timersTable  = "HafenAlarms"
+)
+
+// Timer items keep due time in dt, which is the range key of both indexes,
+// and fire time in at. Items saved before at was introduced have only dt.
+// Warning lead times are kept in seconds as number set, which can't be empty.
+// Assignees are kept as list of maps, their IDs and usernames are duplicated
+// into sets so that scan can filter on them. Timers waiting for server state
+// have trigger attribute instead of enabled. They are listed from
+// trigger-index with hash key trigger (S) and all attributes projected, or
+// by filtered scan of the table if it lacks the index
+
 // DynamoStore implements Store interface and communicate to DynamoDB
 type DynamoStore struct {
 	db *dynamodb.DynamoDB
+	// noTriggerIndex is set once trigger-index turned out to be missing
+	noTriggerIndex atomic.Bool
 }
 
-// GetMongoStore returns prepared Store
+// GetDynamoStore returns prepared Store
 func GetDynamoStore() (storage.Storage, error) {
 	dyn := &DynamoStore{}
 
@@ -32,86 +54,465 @@ func GetDynamoStore() (storage.Storage, error) {
 	return dyn, nil
 }
 
-func (dyn *DynamoStore) GetSSChats() (chats []int64) {
-	// log.Println("[getSSChats]: Stub!")
-	dyParams := &dynamodb.GetItemInput{
-		TableName: aws.String("HafenTable"),
-		Key: map[string]*dynamodb.AttributeValue{
-			"Service": {
-				S: aws.String("ServerStatus"),
-			},
+// isConditionFailed reports whether err is a failed ConditionExpression
+// of single write or of transaction
+func isConditionFailed(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	return aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException ||
+		aerr.Code() == dynamodb.ErrCodeTransactionCanceledException
+}
+
+// wrapErr translates DynamoDB errors into storage errors
+func wrapErr(op string, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case isConditionFailed(err):
+		return fmt.Errorf("dynamodb: %s: %w", op, storage.ErrConflict)
+	}
+	return fmt.Errorf("dynamodb: %s: %w", op, err)
+}
+
+// itemToTimer converts DynamoDB item into timer
+func itemToTimer(item map[string]*dynamodb.AttributeValue) *timer.Timer {
+	chatid, _ := strconv.ParseInt(aws.StringValue(item["chatid"].N), 10, 64)
+	due, _ := strconv.ParseInt(aws.StringValue(item["dt"].N), 10, 64)
+	at := due
+	if item["at"] != nil {
+		at, _ = strconv.ParseInt(aws.StringValue(item["at"].N), 10, 64)
+	}
+	var warnings []time.Duration
+	if item["warnings"] != nil {
+		for _, n := range item["warnings"].NS {
+			secs, _ := strconv.ParseInt(aws.StringValue(n), 10, 64)
+			warnings = append(warnings, time.Duration(secs)*time.Second)
+		}
+		sort.Slice(warnings, func(i, j int) bool { return warnings[i] > warnings[j] })
+	}
+	return &timer.Timer{
+		ChatID:       chatid,
+		At:           time.Unix(at, 0),
+		Body:         aws.StringValue(item["body"].S),
+		ID:           aws.StringValue(item["id"].S),
+		Due:          time.Unix(due, 0),
+		Attempts:     int(numAttr(item, "attempts")),
+		Warnings:     warnings,
+		Warned:       int(numAttr(item, "warned")),
+		CreatorID:    int(numAttr(item, "creatorid")),
+		CreatorName:  aws.StringValue(stringAttr(item, "creatorname")),
+		NagEvery:     time.Duration(numAttr(item, "nagevery")) * time.Second,
+		NagCount:     int(numAttr(item, "nagcount")),
+		NagMention:   boolAttr(item, "nagmention"),
+		Fired:        boolAttr(item, "fired"),
+		Nagged:       int(numAttr(item, "nagged")),
+		Assignees:    itemToAssignees(item),
+		DeliverTo:    numAttr(item, "deliverto"),
+		Trigger:      aws.StringValue(stringAttr(item, "trigger")),
+		TriggerEvery: boolAttr(item, "triggerevery"),
+	}
+}
+
+func itemToAssignees(item map[string]*dynamodb.AttributeValue) (assignees []timer.Assignee) {
+	if item["assignees"] == nil {
+		return nil
+	}
+	for _, av := range item["assignees"].L {
+		assignees = append(assignees, timer.Assignee{
+			UserID:   int(numAttr(av.M, "userid")),
+			Username: aws.StringValue(stringAttr(av.M, "username")),
+			Name:     aws.StringValue(stringAttr(av.M, "name")),
+		})
+	}
+	return assignees
+}
+
+// putAssignees adds assignees attributes to timer item
+func putAssignees(item map[string]*dynamodb.AttributeValue, assignees []timer.Assignee) {
+	var list []*dynamodb.AttributeValue
+	var ids, names []string
+	for _, a := range assignees {
+		m := map[string]*dynamodb.AttributeValue{
+			"userid": {N: aws.String(fmt.Sprintf("%d", a.UserID))},
+		}
+		if a.UserID != 0 {
+			ids = append(ids, fmt.Sprintf("%d", a.UserID))
+		}
+		if a.Username != "" {
+			m["username"] = &dynamodb.AttributeValue{S: aws.String(a.Username)}
+			names = append(names, a.Username)
+		}
+		if a.Name != "" {
+			m["name"] = &dynamodb.AttributeValue{S: aws.String(a.Name)}
+		}
+		list = append(list, &dynamodb.AttributeValue{M: m})
+	}
+	if len(list) > 0 {
+		item["assignees"] = &dynamodb.AttributeValue{L: list}
+	}
+	if len(ids) > 0 {
+		item["assigneeids"] = &dynamodb.AttributeValue{NS: aws.StringSlice(ids)}
+	}
+	if len(names) > 0 {
+		item["assigneenames"] = &dynamodb.AttributeValue{SS: aws.StringSlice(names)}
+	}
+}
+
+// numAttr returns number attribute of item or 0 if it is missing
+func numAttr(item map[string]*dynamodb.AttributeValue, name string) int64 {
+	if item[name] == nil {
+		return 0
+	}
+	n, _ := strconv.ParseInt(aws.StringValue(item[name].N), 10, 64)
+	return n
+}
+
+// stringAttr returns string attribute of item or nil if it is missing
+func stringAttr(item map[string]*dynamodb.AttributeValue, name string) *string {
+	if item[name] == nil {
+		return nil
+	}
+	return item[name].S
+}
+
+// boolAttr returns boolean attribute of item or false if it is missing
+func boolAttr(item map[string]*dynamodb.AttributeValue, name string) bool {
+	if item[name] == nil {
+		return false
+	}
+	return aws.BoolValue(item[name].BOOL)
+}
+
+// warningsAttr converts warning lead times into number set of seconds
+func warningsAttr(warnings []time.Duration) *dynamodb.AttributeValue {
+	secs := make([]string, 0, len(warnings))
+	for _, w := range warnings {
+		secs = append(secs, fmt.Sprintf("%d", int64(w/time.Second)))
+	}
+	return &dynamodb.AttributeValue{NS: aws.StringSlice(secs)}
+}
+
+// listKey is the key of the item holding a list of subscribed chats
+func listKey(service string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"Service": {
+			S: aws.String(service),
 		},
-		ReturnConsumedCapacity: aws.String("TOTAL"),
 	}
-	resp, err := dyn.db.GetItem(dyParams)
+}
+
+// ssKey is the key of the item holding server status subscriptions
+func ssKey() map[string]*dynamodb.AttributeValue {
+	return listKey("ServerStatus")
+}
+
+// newsKey is the key of the item holding game news subscriptions
+func newsKey() map[string]*dynamodb.AttributeValue {
+	return listKey("News")
+}
+
+// clientKey is the key of the item holding client update subscriptions
+func clientKey() map[string]*dynamodb.AttributeValue {
+	return listKey("ClientVersion")
+}
+
+// Ping checks that DynamoDB tables are reachable
+func (dyn *DynamoStore) Ping(ctx context.Context) error {
+	_, err := dyn.db.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(timersTable),
+	})
+	return wrapErr("ping", err)
+}
+
+// Close drops idle connections to DynamoDB
+func (dyn *DynamoStore) Close() error {
+	if client := dyn.db.Config.HTTPClient; client != nil {
+		client.CloseIdleConnections()
+	}
+	return nil
+}
+
+// listChats returns chats of subscription list item with given key
+func (dyn *DynamoStore) listChats(ctx context.Context, key map[string]*dynamodb.AttributeValue, op string) (chats []int64, err error) {
+	dyParams := &dynamodb.GetItemInput{
+		TableName: aws.String(serviceTable),
+		Key:       key,
+	}
+	resp, err := dyn.db.GetItemWithContext(ctx, dyParams)
 	if err != nil {
-		log.Println(err.Error())
+		return nil, wrapErr(op, err)
+	}
+	if resp.Item["Chats"] == nil {
+		return nil, nil
 	}
-	fmt.Println(resp)
 
 	for _, chatSTR := range resp.Item["Chats"].NS {
-		chatID, _ := strconv.ParseInt(*chatSTR, 10, 64)
-		log.Printf("Sending to: %d", chatID)
+		chatID, err := strconv.ParseInt(*chatSTR, 10, 64)
+		if err != nil {
+			return nil, wrapErr(op, err)
+		}
 		chats = append(chats, chatID)
 	}
-	log.Printf("Consumed: %f units", *resp.ConsumedCapacity.CapacityUnits)
-	return chats
+	return chats, nil
 }
 
-func (dyn *DynamoStore) AppendToSSList(chatID int64) (err error) {
-	// log.Println("[appendToSSList]: Stub!")
+// appendToList adds chatID to subscription list item with given key
+func (dyn *DynamoStore) appendToList(ctx context.Context, key map[string]*dynamodb.AttributeValue, op string, chatID int64) (err error) {
 	chatIDStr := fmt.Sprintf("%d", chatID)
 	dyParams := &dynamodb.UpdateItemInput{
-		Key: map[string]*dynamodb.AttributeValue{
-			"Service": {
-				S: aws.String("ServerStatus"),
-			},
-		},
+		Key: key,
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
 			":val1": {NS: aws.StringSlice([]string{chatIDStr})},
+			":chat": {N: aws.String(chatIDStr)},
 		},
-		UpdateExpression: aws.String("add Chats :val1"),
-		TableName:        aws.String("HafenTable"),
+		UpdateExpression:    aws.String("add Chats :val1"),
+		ConditionExpression: aws.String("not contains(Chats, :chat)"),
+		TableName:           aws.String(serviceTable),
 	}
-	_, err = dyn.db.UpdateItem(dyParams)
-	if err != nil {
-		return
+	_, err = dyn.db.UpdateItemWithContext(ctx, dyParams)
+	if isConditionFailed(err) {
+		return fmt.Errorf("dynamodb: %s: %w", op, storage.ErrAlreadySubscribed)
 	}
-	return
+	return wrapErr(op, err)
 }
 
-func (dyn *DynamoStore) DeleteFromSSList(chatID int64) {
-	// log.Println("[appendToSSList]: Stub!")
+// deleteFromList removes chatID from subscription list item with given key
+func (dyn *DynamoStore) deleteFromList(ctx context.Context, key map[string]*dynamodb.AttributeValue, op string, chatID int64) error {
 	chatIDStr := fmt.Sprintf("%d", chatID)
 	dyParams := &dynamodb.UpdateItemInput{
-		Key: map[string]*dynamodb.AttributeValue{
-			"Service": {
-				S: aws.String("ServerStatus"),
-			},
-		},
+		Key: key,
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
 			":val1": {NS: aws.StringSlice([]string{chatIDStr})},
+			":chat": {N: aws.String(chatIDStr)},
 		},
-		UpdateExpression: aws.String("delete Chats :val1"),
-		TableName:        aws.String("HafenTable"),
+		UpdateExpression:    aws.String("delete Chats :val1"),
+		ConditionExpression: aws.String("contains(Chats, :chat)"),
+		TableName:           aws.String(serviceTable),
+	}
+	_, err := dyn.db.UpdateItemWithContext(ctx, dyParams)
+	if isConditionFailed(err) {
+		return fmt.Errorf("dynamodb: %s: %w", op, storage.ErrNotFound)
 	}
-	_, err := dyn.db.UpdateItem(dyParams)
+	return wrapErr(op, err)
+}
+
+// GetSSChats return array of chats subscribed to server status changes
+func (dyn *DynamoStore) GetSSChats(ctx context.Context) ([]int64, error) {
+	return dyn.listChats(ctx, ssKey(), "get ss chats")
+}
+
+// AppendToSSList adds chatID to list of subscribtions of server status changes
+func (dyn *DynamoStore) AppendToSSList(ctx context.Context, chatID int64) error {
+	return dyn.appendToList(ctx, ssKey(), "append to ss list", chatID)
+}
+
+// DeleteFromSSList removes chatID from list of subscriptions of server status changes
+func (dyn *DynamoStore) DeleteFromSSList(ctx context.Context, chatID int64) error {
+	return dyn.deleteFromList(ctx, ssKey(), "delete from ss list", chatID)
+}
+
+// GetNewsChats returns chats subscribed to game news
+func (dyn *DynamoStore) GetNewsChats(ctx context.Context) ([]int64, error) {
+	return dyn.listChats(ctx, newsKey(), "get news chats")
+}
+
+// AppendToNewsList adds chatID to list of subscriptions of game news
+func (dyn *DynamoStore) AppendToNewsList(ctx context.Context, chatID int64) error {
+	return dyn.appendToList(ctx, newsKey(), "append to news list", chatID)
+}
+
+// DeleteFromNewsList removes chatID from list of subscriptions of game news
+func (dyn *DynamoStore) DeleteFromNewsList(ctx context.Context, chatID int64) error {
+	return dyn.deleteFromList(ctx, newsKey(), "delete from news list", chatID)
+}
+
+// GetClientChats returns chats subscribed to client updates
+func (dyn *DynamoStore) GetClientChats(ctx context.Context) ([]int64, error) {
+	return dyn.listChats(ctx, clientKey(), "get client chats")
+}
+
+// AppendToClientList adds chatID to list of subscriptions of client updates
+func (dyn *DynamoStore) AppendToClientList(ctx context.Context, chatID int64) error {
+	return dyn.appendToList(ctx, clientKey(), "append to client list", chatID)
+}
+
+// DeleteFromClientList removes chatID from list of subscriptions of client updates
+func (dyn *DynamoStore) DeleteFromClientList(ctx context.Context, chatID int64) error {
+	return dyn.deleteFromList(ctx, clientKey(), "delete from client list", chatID)
+}
+
+// Watermarks are kept in service table, one item per name
+const watermarkKeyPrefix = "watermark:"
+
+// watermarkKey is the key of the item holding watermark with given name
+func watermarkKey(name string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"Service": {S: aws.String(watermarkKeyPrefix + name)},
+	}
+}
+
+// scanPrefix calls fn for every service table item with key starting with prefix
+func (dyn *DynamoStore) scanPrefix(ctx context.Context, prefix string, fn func(map[string]*dynamodb.AttributeValue) error) error {
+	dyParams := &dynamodb.ScanInput{
+		TableName:        aws.String(serviceTable),
+		FilterExpression: aws.String("begins_with(Service, :prefix)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":prefix": {S: aws.String(prefix)},
+		},
+	}
+	var fnErr error
+	err := dyn.db.ScanPagesWithContext(ctx, dyParams, func(page *dynamodb.ScanOutput, last bool) bool {
+		for _, item := range page.Items {
+			if fnErr = fn(item); fnErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	return err
+}
+
+// GetWatermark returns value saved under name
+func (dyn *DynamoStore) GetWatermark(ctx context.Context, name string) (string, error) {
+	resp, err := dyn.db.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(serviceTable),
+		Key:       watermarkKey(name),
+	})
+	if err != nil {
+		return "", wrapErr("get watermark", err)
+	}
+	if resp.Item == nil || resp.Item["Value"] == nil {
+		return "", fmt.Errorf("dynamodb: get watermark: %w", storage.ErrNotFound)
+	}
+	return aws.StringValue(resp.Item["Value"].S), nil
+}
+
+// SaveWatermark creates or replaces value under name
+func (dyn *DynamoStore) SaveWatermark(ctx context.Context, name string, value string) error {
+	item := watermarkKey(name)
+	item["Value"] = &dynamodb.AttributeValue{S: aws.String(value)}
+	_, err := dyn.db.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(serviceTable),
+		Item:      item,
+	})
+	return wrapErr("save watermark", err)
+}
+
+// ListWatermarks returns all watermarks by name, scanning service table
+func (dyn *DynamoStore) ListWatermarks(ctx context.Context) (map[string]string, error) {
+	marks := make(map[string]string)
+	err := dyn.scanPrefix(ctx, watermarkKeyPrefix, func(item map[string]*dynamodb.AttributeValue) error {
+		name := strings.TrimPrefix(aws.StringValue(item["Service"].S), watermarkKeyPrefix)
+		marks[name] = aws.StringValue(stringAttr(item, "Value"))
+		return nil
+	})
 	if err != nil {
-		log.Println(err.Error())
+		return nil, wrapErr("list watermarks", err)
 	}
-	// log.Println(resp)
+	return marks, nil
 }
 
-func (dyn *DynamoStore) SaveTimer(timer *timer.Timer) error {
-	// log.Println("[saveTimer]: Stub!")
+// Chat settings are kept in service table next to server status
+// subscriptions, one item per chat
+const settingsKeyPrefix = "settings:"
+
+func settingsKey(chatID int64) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"Service": {S: aws.String(fmt.Sprintf("%s%d", settingsKeyPrefix, chatID))},
+	}
+}
+
+// GetChatSettings returns settings of chat from DynamoDB
+func (dyn *DynamoStore) GetChatSettings(ctx context.Context, chatID int64) (*settings.Chat, error) {
+	resp, err := dyn.db.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(serviceTable),
+		Key:       settingsKey(chatID),
+	})
+	if err != nil {
+		return nil, wrapErr("get chat settings", err)
+	}
+	if resp.Item == nil {
+		return nil, fmt.Errorf("dynamodb: get chat settings: %w", storage.ErrNotFound)
+	}
+	var c settings.Chat
+	if err = dynamodbattribute.UnmarshalMap(resp.Item, &c); err != nil {
+		return nil, wrapErr("get chat settings", err)
+	}
+	return &c, nil
+}
+
+// SaveChatSettings creates or replaces settings of chat in DynamoDB
+func (dyn *DynamoStore) SaveChatSettings(ctx context.Context, c *settings.Chat) error {
+	item, err := dynamodbattribute.MarshalMap(c)
+	if err != nil {
+		return wrapErr("save chat settings", err)
+	}
+	for k, v := range settingsKey(c.ChatID) {
+		item[k] = v
+	}
+	_, err = dyn.db.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(serviceTable),
+		Item:      item,
+	})
+	return wrapErr("save chat settings", err)
+}
+
+// ListChatSettings returns settings of all chats, scanning service table
+func (dyn *DynamoStore) ListChatSettings(ctx context.Context) (chats []settings.Chat, err error) {
+	dyParams := &dynamodb.ScanInput{
+		TableName:        aws.String(serviceTable),
+		FilterExpression: aws.String("begins_with(Service, :prefix)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":prefix": {S: aws.String(settingsKeyPrefix)},
+		},
+	}
+	var decodeErr error
+	err = dyn.db.ScanPagesWithContext(ctx, dyParams, func(page *dynamodb.ScanOutput, last bool) bool {
+		for _, item := range page.Items {
+			var c settings.Chat
+			if decodeErr = dynamodbattribute.UnmarshalMap(item, &c); decodeErr != nil {
+				return false
+			}
+			chats = append(chats, c)
+		}
+		return true
+	})
+	if decodeErr != nil {
+		return nil, wrapErr("list chat settings", decodeErr)
+	}
+	if err != nil {
+		return nil, wrapErr("list chat settings", err)
+	}
+	return chats, nil
+}
+
+// SaveTimer saves the timer into DynamoDB. ID is generated unless already set
+func (dyn *DynamoStore) SaveTimer(ctx context.Context, timer *timer.Timer) error {
+	if timer.ID == "" {
+		timer.ID = fmt.Sprintf("%s", uuid.NewV4())
+	}
+	if timer.Due.IsZero() {
+		timer.Due = timer.NextDue()
+	}
 	dyParams := &dynamodb.PutItemInput{
-		TableName: aws.String("HafenAlarms"),
+		TableName: aws.String(timersTable),
 		Item: map[string]*dynamodb.AttributeValue{
 			"dt": {
+				N: aws.String(fmt.Sprintf("%d", timer.Due.Unix())),
+			},
+			"at": {
 				N: aws.String(fmt.Sprintf("%d", timer.At.Unix())),
 			},
+			"attempts": {
+				N: aws.String(fmt.Sprintf("%d", timer.Attempts)),
+			},
 			"id": {
-				S: aws.String(fmt.Sprintf("%s", uuid.NewV4())),
+				S: aws.String(timer.ID),
 			},
 			"chatid": {
 				N: aws.String(fmt.Sprintf("%d", timer.ChatID)),
@@ -122,135 +523,670 @@ func (dyn *DynamoStore) SaveTimer(timer *timer.Timer) error {
 			"enabled": {
 				N: aws.String("1"),
 			},
+			"warned": {
+				N: aws.String(fmt.Sprintf("%d", timer.Warned)),
+			},
+			"creatorid": {
+				N: aws.String(fmt.Sprintf("%d", timer.CreatorID)),
+			},
+			"nagevery": {
+				N: aws.String(fmt.Sprintf("%d", int64(timer.NagEvery/time.Second))),
+			},
+			"nagcount": {
+				N: aws.String(fmt.Sprintf("%d", timer.NagCount)),
+			},
+			"nagmention": {
+				BOOL: aws.Bool(timer.NagMention),
+			},
+			"fired": {
+				BOOL: aws.Bool(timer.Fired),
+			},
+			"nagged": {
+				N: aws.String(fmt.Sprintf("%d", timer.Nagged)),
+			},
+			"deliverto": {
+				N: aws.String(fmt.Sprintf("%d", timer.DeliverTo)),
+			},
 		},
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
 	}
-	_, err := dyn.db.PutItem(dyParams)
-	if err != nil {
-		return err
+	// empty strings can't be stored
+	if timer.CreatorName != "" {
+		dyParams.Item["creatorname"] = &dynamodb.AttributeValue{S: aws.String(timer.CreatorName)}
 	}
-	// log.Println(resp)
-	return nil
+	putAssignees(dyParams.Item, timer.Assignees)
+	if len(timer.Warnings) > 0 {
+		dyParams.Item["warnings"] = warningsAttr(timer.Warnings)
+	}
+	// timers waiting for server state are kept out of enabled-dt-index
+	if timer.Trigger != "" {
+		delete(dyParams.Item, "enabled")
+		dyParams.Item["trigger"] = &dynamodb.AttributeValue{S: aws.String(timer.Trigger)}
+		dyParams.Item["triggerevery"] = &dynamodb.AttributeValue{BOOL: aws.Bool(timer.TriggerEvery)}
+	}
+	_, err := dyn.db.PutItemWithContext(ctx, dyParams)
+	return wrapErr("save timer", err)
 }
 
-func (dyn *DynamoStore) ListChatTimers(ChatID int64) (timers []timer.Timer, err error) {
-	dyParams := &dynamodb.QueryInput{
-		TableName:              aws.String("HafenAlarms"),
-		IndexName:              aws.String("chatid-dt-index"),
-		KeyConditionExpression: aws.String("chatid = :chtid"),
+// UpdateTimer replaces fire time, text, warnings and progress of the timer in DynamoDB.
+// Timer is rescheduled to its next due time unless its due changed since it was read
+func (dyn *DynamoStore) UpdateTimer(ctx context.Context, timer *timer.Timer) error {
+	dyParams := &dynamodb.UpdateItemInput{
+		TableName: aws.String(timersTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(timer.ID),
+			},
+		},
+		UpdateExpression:    aws.String("set dt = :dt, #at = :at, body = :body, warned = :warned, fired = :fired, nagged = :nagged remove warnings"),
+		ConditionExpression: aws.String("chatid = :chtid and dt = :due"),
+		// AT is a reserved word
+		ExpressionAttributeNames: map[string]*string{"#at": aws.String("at")},
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":dt": {
+				N: aws.String(fmt.Sprintf("%d", timer.NextDue().Unix())),
+			},
+			":at": {
+				N: aws.String(fmt.Sprintf("%d", timer.At.Unix())),
+			},
+			":warned": {
+				N: aws.String(fmt.Sprintf("%d", timer.Warned)),
+			},
+			":fired": {
+				BOOL: aws.Bool(timer.Fired),
+			},
+			":nagged": {
+				N: aws.String(fmt.Sprintf("%d", timer.Nagged)),
+			},
+			":body": {
+				S: aws.String(timer.Body),
+			},
 			":chtid": {
-				N: aws.String(fmt.Sprintf("%d", ChatID)),
+				N: aws.String(fmt.Sprintf("%d", timer.ChatID)),
+			},
+			":due": {
+				N: aws.String(fmt.Sprintf("%d", timer.Due.Unix())),
 			},
 		},
 	}
-	resp, err := dyn.db.Query(dyParams)
-	if err != nil {
-		log.Println(err.Error())
+	if len(timer.Warnings) > 0 {
+		dyParams.UpdateExpression = aws.String("set dt = :dt, #at = :at, body = :body, warned = :warned, fired = :fired, nagged = :nagged, warnings = :warnings")
+		dyParams.ExpressionAttributeValues[":warnings"] = warningsAttr(timer.Warnings)
+	}
+	_, err := dyn.db.UpdateItemWithContext(ctx, dyParams)
+	if isConditionFailed(err) {
+		return fmt.Errorf("dynamodb: update timer: %w", storage.ErrConflict)
 	}
-	// log.Println(resp)
-	for _, items := range resp.Items {
-		chatid, _ := strconv.ParseInt(*items["chatid"].N, 10, 64)
-		timestamp, _ := strconv.ParseInt(*items["dt"].N, 10, 64)
-		body := *items["body"].S
-		id := *items["id"].S
-		timers = append(timers, timer.Timer{ChatID: chatid, At: time.Unix(timestamp, 0), Body: body, ID: id})
+	if err == nil {
+		timer.Due = time.Unix(timer.NextDue().Unix(), 0)
 	}
-	return
+	return wrapErr("update timer", err)
 }
 
-func (dyn *DynamoStore) GetTimerByChatAndID(ChatID int64, ID string) (rtimer *timer.Timer, err error) {
+// ClaimTimer moves due of the timer to until unless someone did it before
+func (dyn *DynamoStore) ClaimTimer(ctx context.Context, timer *timer.Timer, until time.Time) error {
+	dyParams := &dynamodb.UpdateItemInput{
+		TableName: aws.String(timersTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(timer.ID),
+			},
+		},
+		UpdateExpression:    aws.String("set dt = :until add attempts :one"),
+		ConditionExpression: aws.String("dt = :due"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":until": {
+				N: aws.String(fmt.Sprintf("%d", until.Unix())),
+			},
+			":due": {
+				N: aws.String(fmt.Sprintf("%d", timer.Due.Unix())),
+			},
+			":one": {
+				N: aws.String("1"),
+			},
+		},
+	}
+	_, err := dyn.db.UpdateItemWithContext(ctx, dyParams)
+	if err != nil {
+		return wrapErr("claim timer", err)
+	}
+	timer.Due = time.Unix(until.Unix(), 0)
+	timer.Attempts++
+	return nil
+}
+
+// ListChatTimers returns array of timers by ChatID ordered by time
+func (dyn *DynamoStore) ListChatTimers(ctx context.Context, ChatID int64) (timers []timer.Timer, err error) {
 	dyParams := &dynamodb.QueryInput{
-		TableName:              aws.String("HafenAlarms"),
+		TableName:              aws.String(timersTable),
 		IndexName:              aws.String("chatid-dt-index"),
 		KeyConditionExpression: aws.String("chatid = :chtid"),
-		FilterExpression:       aws.String("id = :id"),
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
 			":chtid": {
 				N: aws.String(fmt.Sprintf("%d", ChatID)),
 			},
-			":id": {
+		},
+	}
+	err = dyn.db.QueryPagesWithContext(ctx, dyParams, func(page *dynamodb.QueryOutput, last bool) bool {
+		for _, item := range page.Items {
+			timers = append(timers, *itemToTimer(item))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, wrapErr("list chat timers", err)
+	}
+	return timers, nil
+}
+
+// ListTriggeredTimers returns timers waiting for server state. They are not
+// in enabled-dt-index, but only they have trigger attribute, so trigger-index
+// keyed by it holds nothing else and is scanned instead of the table.
+// Tables created before triggers have no such index, then the whole table
+// is scanned with a filter till restart
+func (dyn *DynamoStore) ListTriggeredTimers(ctx context.Context) (timers []timer.Timer, err error) {
+	dyParams := &dynamodb.ScanInput{
+		TableName: aws.String(timersTable),
+		IndexName: aws.String("trigger-index"),
+	}
+	if dyn.noTriggerIndex.Load() {
+		dyParams = triggerFilterScan()
+	}
+	err = dyn.db.ScanPagesWithContext(ctx, dyParams, func(page *dynamodb.ScanOutput, last bool) bool {
+		for _, item := range page.Items {
+			timers = append(timers, *itemToTimer(item))
+		}
+		return true
+	})
+	if dyParams.IndexName != nil && isMissingIndex(err) {
+		dyn.noTriggerIndex.Store(true)
+		return dyn.ListTriggeredTimers(ctx)
+	}
+	if err != nil {
+		return nil, wrapErr("list triggered timers", err)
+	}
+	return timers, nil
+}
+
+// triggerFilterScan returns scan of timers with trigger over the whole table
+func triggerFilterScan() *dynamodb.ScanInput {
+	return &dynamodb.ScanInput{
+		TableName:        aws.String(timersTable),
+		FilterExpression: aws.String("attribute_exists(#trigger)"),
+		// TRIGGER is a reserved word
+		ExpressionAttributeNames: map[string]*string{"#trigger": aws.String("trigger")},
+	}
+}
+
+// isMissingIndex reports whether err is a query or scan of index the table
+// doesn't have
+func isMissingIndex(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == "ValidationException" && strings.Contains(aerr.Message(), "specified index")
+}
+
+// ListAssignedTimers returns timers assigned to user ordered by time.
+// There is no index on assignees, so the whole table is scanned
+func (dyn *DynamoStore) ListAssignedTimers(ctx context.Context, userID int, username string) (timers []timer.Timer, err error) {
+	filter := "contains(assigneeids, :id)"
+	values := map[string]*dynamodb.AttributeValue{
+		":id": {N: aws.String(fmt.Sprintf("%d", userID))},
+	}
+	if username != "" {
+		filter += " OR contains(assigneenames, :name)"
+		values[":name"] = &dynamodb.AttributeValue{S: aws.String(username)}
+	}
+	dyParams := &dynamodb.ScanInput{
+		TableName:                 aws.String(timersTable),
+		FilterExpression:          aws.String(filter),
+		ExpressionAttributeValues: values,
+	}
+	err = dyn.db.ScanPagesWithContext(ctx, dyParams, func(page *dynamodb.ScanOutput, last bool) bool {
+		for _, item := range page.Items {
+			timers = append(timers, *itemToTimer(item))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, wrapErr("list assigned timers", err)
+	}
+	sort.Slice(timers, func(i, j int) bool { return timers[i].At.Before(timers[j].At) })
+	return timers, nil
+}
+
+// WalkTimers iterates over all timers in DynamoDB in no particular order
+func (dyn *DynamoStore) WalkTimers(ctx context.Context, fn func(*timer.Timer) error) error {
+	dyParams := &dynamodb.ScanInput{
+		TableName: aws.String(timersTable),
+	}
+	var fnErr error
+	err := dyn.db.ScanPagesWithContext(ctx, dyParams, func(page *dynamodb.ScanOutput, last bool) bool {
+		for _, item := range page.Items {
+			if fnErr = fn(itemToTimer(item)); fnErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	return wrapErr("walk timers", err)
+}
+
+// GetTimerByChatAndID returns timer by ChatID and ID from DynamoDB
+func (dyn *DynamoStore) GetTimerByChatAndID(ctx context.Context, ChatID int64, ID string) (*timer.Timer, error) {
+	dyParams := &dynamodb.GetItemInput{
+		TableName: aws.String(timersTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
 				S: aws.String(ID),
 			},
 		},
 	}
-	resp, err := dyn.db.Query(dyParams)
+	resp, err := dyn.db.GetItemWithContext(ctx, dyParams)
 	if err != nil {
-		log.Println(err.Error())
+		return nil, wrapErr("get timer", err)
+	}
+	if resp.Item == nil {
+		return nil, fmt.Errorf("dynamodb: get timer: %w", storage.ErrNotFound)
+	}
+	rtimer := itemToTimer(resp.Item)
+	if rtimer.ChatID != ChatID {
+		return nil, fmt.Errorf("dynamodb: get timer: %w", storage.ErrNotFound)
 	}
-	// log.Println(resp)
-	for _, items := range resp.Items {
-		chatid, _ := strconv.ParseInt(*items["chatid"].N, 10, 64)
-		timestamp, _ := strconv.ParseInt(*items["dt"].N, 10, 64)
-		body := *items["body"].S
-		id := *items["id"].S
+	return rtimer, nil
+}
 
-		rtimer = &timer.Timer{
-			ChatID: chatid,
-			At:     time.Unix(timestamp, 0),
-			Body:   body,
-			ID:     id}
-		return
+// RescheduleTimer moves due of claimed timer and saves its progress
+func (dyn *DynamoStore) RescheduleTimer(ctx context.Context, timer *timer.Timer, due time.Time) error {
+	dyParams := &dynamodb.UpdateItemInput{
+		TableName: aws.String(timersTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(timer.ID),
+			},
+		},
+		UpdateExpression:    aws.String("set dt = :new, warned = :warned, fired = :fired, nagged = :nagged, attempts = :zero"),
+		ConditionExpression: aws.String("dt = :due"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":new": {
+				N: aws.String(fmt.Sprintf("%d", due.Unix())),
+			},
+			":warned": {
+				N: aws.String(fmt.Sprintf("%d", timer.Warned)),
+			},
+			":fired": {
+				BOOL: aws.Bool(timer.Fired),
+			},
+			":nagged": {
+				N: aws.String(fmt.Sprintf("%d", timer.Nagged)),
+			},
+			":zero": {
+				N: aws.String("0"),
+			},
+			":due": {
+				N: aws.String(fmt.Sprintf("%d", timer.Due.Unix())),
+			},
+		},
 	}
-	return
+	_, err := dyn.db.UpdateItemWithContext(ctx, dyParams)
+	if err != nil {
+		return wrapErr("reschedule timer", err)
+	}
+	timer.Due = time.Unix(due.Unix(), 0)
+	timer.Attempts = 0
+	return nil
 }
 
-func (dyn *DynamoStore) GetNearestTimer() (rtimer *timer.Timer, err error) {
-	// log.Println("[getNearestTimer]: Stub!")
-	// epoch := time.Now().Unix()
+// GetNearestTimer returns first timer in DynamoDB by due time
+func (dyn *DynamoStore) GetNearestTimer(ctx context.Context) (*timer.Timer, error) {
 	dyParams := &dynamodb.QueryInput{
-		TableName:              aws.String("HafenAlarms"),
+		TableName:              aws.String(timersTable),
 		IndexName:              aws.String("enabled-dt-index"),
 		KeyConditionExpression: aws.String("enabled = :nbl"),
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			// ":dt": {
-			// 	N: aws.String(fmt.Sprintf("%d", epoch)),
-			// },
 			":nbl": {
 				N: aws.String("1"),
 			},
 		},
 		Limit: aws.Int64(1),
 	}
-	resp, err := dyn.db.Query(dyParams)
+	resp, err := dyn.db.QueryWithContext(ctx, dyParams)
 	if err != nil {
-		return
-	}
-	// log.Println(resp)
-	for _, items := range resp.Items {
-		chatid, _ := strconv.ParseInt(*items["chatid"].N, 10, 64)
-		timestamp, _ := strconv.ParseInt(*items["dt"].N, 10, 64)
-		body := *items["body"].S
-		id := *items["id"].S
-		rtimer = &timer.Timer{
-			ChatID: chatid,
-			At:     time.Unix(timestamp, 0),
-			Body:   body,
-			ID:     id}
-		return
-	}
-	return
-}
-
-func (dyn *DynamoStore) DeleteTimer(ChatID int64, ID string) error {
-	// log.Printf("[deleteTimer]: ChatID: %d, ID: %s\n", ChatID, ID)
-	rtimer, err := dyn.GetTimerByChatAndID(ChatID, ID)
-	if err != nil {
-		return err
+		return nil, wrapErr("get nearest timer", err)
 	}
-	if rtimer == nil {
-		return errors.New("No such timer")
+	if len(resp.Items) == 0 {
+		return nil, nil
 	}
+	return itemToTimer(resp.Items[0]), nil
+}
+
+// DeleteTimer deletes the timer from DynamoDB by ChatID and ID
+func (dyn *DynamoStore) DeleteTimer(ctx context.Context, ChatID int64, ID string) error {
 	dyParams := &dynamodb.DeleteItemInput{
-		TableName: aws.String("HafenAlarms"),
+		TableName: aws.String(timersTable),
 		Key: map[string]*dynamodb.AttributeValue{
 			"id": {
-				S: aws.String(rtimer.ID),
+				S: aws.String(ID),
+			},
+		},
+		ConditionExpression: aws.String("chatid = :chtid"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":chtid": {
+				N: aws.String(fmt.Sprintf("%d", ChatID)),
+			},
+		},
+	}
+	_, err := dyn.db.DeleteItemWithContext(ctx, dyParams)
+	if isConditionFailed(err) {
+		return fmt.Errorf("dynamodb: delete timer: %w", storage.ErrNotFound)
+	}
+	return wrapErr("delete timer", err)
+}
+
+// Chat tokens are kept in service table as two items: one keyed by chat
+// holding the hash and one keyed by hash pointing back to the chat
+const tokenKeyPrefix = "token:"
+
+func tokenChatKey(kind string, chatID int64) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"Service": {S: aws.String(fmt.Sprintf("%s%s:%d", tokenKeyPrefix, kind, chatID))},
+	}
+}
+
+func tokenHashKey(kind string, hash string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"Service": {S: aws.String(fmt.Sprintf("tokenhash:%s:%s", kind, hash))},
+	}
+}
+
+// putTokenHash returns transaction item creating hash to chat pointer
+func putTokenHash(kind string, chatID int64, hash string) *dynamodb.TransactWriteItem {
+	item := tokenHashKey(kind, hash)
+	item["Chat"] = &dynamodb.AttributeValue{N: aws.String(fmt.Sprintf("%d", chatID))}
+	return &dynamodb.TransactWriteItem{
+		Put: &dynamodb.Put{
+			TableName:           aws.String(serviceTable),
+			Item:                item,
+			ConditionExpression: aws.String("attribute_not_exists(Service)"),
+		},
+	}
+}
+
+// getTokenHash returns current hash of chat secret
+func (dyn *DynamoStore) getTokenHash(ctx context.Context, kind string, chatID int64) (string, error) {
+	resp, err := dyn.db.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(serviceTable),
+		Key:            tokenChatKey(kind, chatID),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return "", err
+	}
+	if resp.Item == nil || resp.Item["Hash"] == nil {
+		return "", storage.ErrNotFound
+	}
+	return aws.StringValue(resp.Item["Hash"].S), nil
+}
+
+// CreateChatToken stores hash of chat secret of given kind
+func (dyn *DynamoStore) CreateChatToken(ctx context.Context, kind string, chatID int64, hash string) error {
+	item := tokenChatKey(kind, chatID)
+	item["Hash"] = &dynamodb.AttributeValue{S: aws.String(hash)}
+	_, err := dyn.db.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Put: &dynamodb.Put{
+					TableName:           aws.String(serviceTable),
+					Item:                item,
+					ConditionExpression: aws.String("attribute_not_exists(Service)"),
+				},
 			},
+			putTokenHash(kind, chatID, hash),
 		},
+	})
+	return wrapErr("create chat token", err)
+}
+
+// ReplaceChatToken swaps hash of existing chat secret
+func (dyn *DynamoStore) ReplaceChatToken(ctx context.Context, kind string, chatID int64, hash string) error {
+	oldHash, err := dyn.getTokenHash(ctx, kind, chatID)
+	if err != nil {
+		return wrapErr("replace chat token", err)
 	}
-	_, err = dyn.db.DeleteItem(dyParams)
+	_, err = dyn.db.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Update: &dynamodb.Update{
+					TableName:           aws.String(serviceTable),
+					Key:                 tokenChatKey(kind, chatID),
+					UpdateExpression:    aws.String("set Hash = :new"),
+					ConditionExpression: aws.String("Hash = :old"),
+					ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+						":new": {S: aws.String(hash)},
+						":old": {S: aws.String(oldHash)},
+					},
+				},
+			},
+			{
+				Delete: &dynamodb.Delete{
+					TableName: aws.String(serviceTable),
+					Key:       tokenHashKey(kind, oldHash),
+				},
+			},
+			putTokenHash(kind, chatID, hash),
+		},
+	})
+	return wrapErr("replace chat token", err)
+}
+
+// DeleteChatToken removes chat secret of given kind
+func (dyn *DynamoStore) DeleteChatToken(ctx context.Context, kind string, chatID int64) error {
+	oldHash, err := dyn.getTokenHash(ctx, kind, chatID)
 	if err != nil {
-		return err
+		return wrapErr("delete chat token", err)
 	}
-	return nil
+	_, err = dyn.db.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Delete: &dynamodb.Delete{
+					TableName:           aws.String(serviceTable),
+					Key:                 tokenChatKey(kind, chatID),
+					ConditionExpression: aws.String("Hash = :old"),
+					ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+						":old": {S: aws.String(oldHash)},
+					},
+				},
+			},
+			{
+				Delete: &dynamodb.Delete{
+					TableName: aws.String(serviceTable),
+					Key:       tokenHashKey(kind, oldHash),
+				},
+			},
+		},
+	})
+	return wrapErr("delete chat token", err)
+}
+
+// GetChatByToken returns chat owning secret with given hash
+func (dyn *DynamoStore) GetChatByToken(ctx context.Context, kind string, hash string) (int64, error) {
+	resp, err := dyn.db.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(serviceTable),
+		Key:       tokenHashKey(kind, hash),
+	})
+	if err != nil {
+		return 0, wrapErr("get chat by token", err)
+	}
+	if resp.Item == nil || resp.Item["Chat"] == nil {
+		return 0, fmt.Errorf("dynamodb: get chat by token: %w", storage.ErrNotFound)
+	}
+	chatID, err := strconv.ParseInt(aws.StringValue(resp.Item["Chat"].N), 10, 64)
+	if err != nil {
+		return 0, wrapErr("get chat by token", err)
+	}
+	return chatID, nil
+}
+
+// ListChatTokens returns secrets of all kinds and chats, scanning service
+// table for items keyed by chat
+func (dyn *DynamoStore) ListChatTokens(ctx context.Context) (tokens []storage.ChatToken, err error) {
+	err = dyn.scanPrefix(ctx, tokenKeyPrefix, func(item map[string]*dynamodb.AttributeValue) error {
+		key := strings.TrimPrefix(aws.StringValue(item["Service"].S), tokenKeyPrefix)
+		sep := strings.LastIndex(key, ":")
+		if sep < 0 {
+			return fmt.Errorf("bad token key %q", key)
+		}
+		chatID, err := strconv.ParseInt(key[sep+1:], 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad token key %q: %w", key, err)
+		}
+		tokens = append(tokens, storage.ChatToken{Kind: key[:sep], ChatID: chatID, Hash: aws.StringValue(stringAttr(item, "Hash"))})
+		return nil
+	})
+	if err != nil {
+		return nil, wrapErr("list chat tokens", err)
+	}
+	return tokens, nil
+}
+
+// Plots are kept in service table, one item per plot keyed by chat and name.
+// Listing them scans the table, which holds only a few small items
+const plotsKeyPrefix = "plot:"
+
+func plotKeyPrefix(chatID int64) string {
+	return fmt.Sprintf("%s%d:", plotsKeyPrefix, chatID)
+}
+
+func plotKey(chatID int64, name string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"Service": {S: aws.String(plotKeyPrefix(chatID) + name)},
+	}
+}
+
+// SavePlot saves crop plot into DynamoDB
+func (dyn *DynamoStore) SavePlot(ctx context.Context, p *plot.Plot) error {
+	item, err := dynamodbattribute.MarshalMap(p)
+	if err != nil {
+		return wrapErr("save plot", err)
+	}
+	for k, v := range plotKey(p.ChatID, p.Name) {
+		item[k] = v
+	}
+	_, err = dyn.db.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(serviceTable),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(Service)"),
+	})
+	return wrapErr("save plot", err)
+}
+
+// ListChatPlots returns plots of chat ordered by planting time
+func (dyn *DynamoStore) ListChatPlots(ctx context.Context, chatID int64) (plots []plot.Plot, err error) {
+	dyParams := &dynamodb.ScanInput{
+		TableName:        aws.String(serviceTable),
+		FilterExpression: aws.String("begins_with(Service, :prefix)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":prefix": {S: aws.String(plotKeyPrefix(chatID))},
+		},
+	}
+	var decodeErr error
+	err = dyn.db.ScanPagesWithContext(ctx, dyParams, func(page *dynamodb.ScanOutput, last bool) bool {
+		for _, item := range page.Items {
+			var p plot.Plot
+			if decodeErr = dynamodbattribute.UnmarshalMap(item, &p); decodeErr != nil {
+				return false
+			}
+			plots = append(plots, p)
+		}
+		return true
+	})
+	if decodeErr != nil {
+		return nil, wrapErr("list chat plots", decodeErr)
+	}
+	if err != nil {
+		return nil, wrapErr("list chat plots", err)
+	}
+	sort.Slice(plots, func(i, j int) bool { return plots[i].Planted.Before(plots[j].Planted) })
+	return plots, nil
+}
+
+// DeletePlot deletes plot from DynamoDB by ChatID and name
+func (dyn *DynamoStore) DeletePlot(ctx context.Context, chatID int64, name string) error {
+	_, err := dyn.db.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName:           aws.String(serviceTable),
+		Key:                 plotKey(chatID, name),
+		ConditionExpression: aws.String("attribute_exists(Service)"),
+	})
+	if isConditionFailed(err) {
+		return fmt.Errorf("dynamodb: delete plot: %w", storage.ErrNotFound)
+	}
+	return wrapErr("delete plot", err)
+}
+
+// ListPlots returns plots of all chats, scanning service table
+func (dyn *DynamoStore) ListPlots(ctx context.Context) (plots []plot.Plot, err error) {
+	err = dyn.scanPrefix(ctx, plotsKeyPrefix, func(item map[string]*dynamodb.AttributeValue) error {
+		var p plot.Plot
+		if err := dynamodbattribute.UnmarshalMap(item, &p); err != nil {
+			return err
+		}
+		plots = append(plots, p)
+		return nil
+	})
+	if err != nil {
+		return nil, wrapErr("list plots", err)
+	}
+	return plots, nil
+}
+
+// leaseKey is the key of the service table item holding named lease
+func leaseKey(name string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"Service": {S: aws.String("lease:" + name)},
+	}
+}
+
+// AcquireLease takes or extends named lease kept in service table
+func (dyn *DynamoStore) AcquireLease(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	item := leaseKey(name)
+	item["Holder"] = &dynamodb.AttributeValue{S: aws.String(holder)}
+	item["Until"] = &dynamodb.AttributeValue{N: aws.String(fmt.Sprintf("%d", now.Add(ttl).UnixMilli()))}
+	_, err := dyn.db.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(serviceTable),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(Service) OR Holder = :holder OR #until < :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#until": aws.String("Until"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":holder": {S: aws.String(holder)},
+			":now":    {N: aws.String(fmt.Sprintf("%d", now.UnixMilli()))},
+		},
+	})
+	if isConditionFailed(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, wrapErr("acquire lease", err)
+	}
+	return true, nil
+}
+
+// ReleaseLease removes named lease if it is held by holder
+func (dyn *DynamoStore) ReleaseLease(ctx context.Context, name string, holder string) error {
+	_, err := dyn.db.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName:           aws.String(serviceTable),
+		Key:                 leaseKey(name),
+		ConditionExpression: aws.String("Holder = :holder"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":holder": {S: aws.String(holder)},
+		},
+	})
+	if isConditionFailed(err) {
+		return nil
+	}
+	return wrapErr("release lease", err)
 }
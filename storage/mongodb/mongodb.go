@@ -1,14 +1,16 @@
 package mongodb
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
-	"log"
 	"net"
-	"os"
 	"strings"
+	"time"
 
+	"github.com/mementor/hafenbot/plot"
+	"github.com/mementor/hafenbot/settings"
 	"github.com/mementor/hafenbot/storage"
 	"github.com/mementor/hafenbot/timer"
 	uuid "github.com/satori/go.uuid"
@@ -46,121 +48,710 @@ func GetMongoStore(uri string) (storage.Storage, error) {
 
 	msess, err := mgo.DialWithInfo(dialInfo)
 	if err != nil {
-		fmt.Println("Failed to connect: ", err)
-		os.Exit(1)
+		return mstore, fmt.Errorf("mongodb: connect: %w", err)
 	}
 
 	mstore.msess = msess
 	mstore.msess.SetMode(mgo.Monotonic, true)
+
+	err = mstore.msess.DB("TimerBot").C("timers").EnsureIndex(mgo.Index{Key: []string{"id"}, Unique: true})
+	if err != nil {
+		return mstore, err
+	}
+	err = mstore.msess.DB("TimerBot").C("timers").EnsureIndexKey("due")
+	if err != nil {
+		return mstore, err
+	}
+	err = mstore.msess.DB("TimerBot").C("timers").EnsureIndexKey("assignees.userid")
+	if err != nil {
+		return mstore, err
+	}
+	err = mstore.msess.DB("TimerBot").C("timers").EnsureIndexKey("assignees.username")
+	if err != nil {
+		return mstore, err
+	}
+	err = mstore.msess.DB("TimerBot").C("timers").EnsureIndexKey("trigger")
+	if err != nil {
+		return mstore, err
+	}
+	if err = mstore.backfillDue(); err != nil {
+		return mstore, err
+	}
+	err = mstore.msess.DB("TimerBot").C("plots").EnsureIndex(mgo.Index{Key: []string{"chatid", "name"}, Unique: true})
+	if err != nil {
+		return mstore, err
+	}
+	err = mstore.msess.DB("TimerBot").C("tokens").EnsureIndex(mgo.Index{Key: []string{"kind", "hash"}, Unique: true})
+	if err != nil {
+		return mstore, err
+	}
 	return mstore, nil
 }
 
-// SaveTimer saves the timer into MongoDB
-func (mstore *MongoStore) SaveTimer(timer *timer.Timer) error {
-	timer.ID = fmt.Sprintf("%s", uuid.NewV4())
+// backfillDue sets due of timers saved before it was introduced
+func (mstore *MongoStore) backfillDue() error {
 	TimersCollection := mstore.msess.DB("TimerBot").C("timers")
-	err := TimersCollection.Insert(timer)
+	iter := TimersCollection.Find(bson.M{"due": bson.M{"$exists": false}}).Iter()
+	var t timer.Timer
+	for iter.Next(&t) {
+		err := TimersCollection.Update(bson.M{"id": t.ID}, bson.M{"$set": bson.M{"due": t.At}})
+		if err != nil && err != mgo.ErrNotFound {
+			iter.Close()
+			return wrapErr("backfill due", err)
+		}
+	}
+	return wrapErr("backfill due", iter.Close())
+}
+
+// session returns a copy of the master session limited by ctx deadline.
+// mgo knows nothing about contexts, so cancellation is only checked upfront
+func (mstore *MongoStore) session(ctx context.Context) (*mgo.Session, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	sess := mstore.msess.Copy()
+	if deadline, ok := ctx.Deadline(); ok {
+		sess.SetSocketTimeout(time.Until(deadline))
+	}
+	return sess, nil
+}
+
+// wrapErr translates mgo errors into storage errors
+func wrapErr(op string, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case err == mgo.ErrNotFound:
+		return fmt.Errorf("mongodb: %s: %w", op, storage.ErrNotFound)
+	case mgo.IsDup(err):
+		return fmt.Errorf("mongodb: %s: %w", op, storage.ErrConflict)
+	}
+	return fmt.Errorf("mongodb: %s: %w", op, err)
+}
+
+// Ping checks connection to MongoDB
+func (mstore *MongoStore) Ping(ctx context.Context) error {
+	sess, err := mstore.session(ctx)
 	if err != nil {
 		return err
 	}
+	defer sess.Close()
+	return wrapErr("ping", sess.Ping())
+}
+
+// Close closes connections to MongoDB
+func (mstore *MongoStore) Close() error {
+	mstore.msess.Close()
 	return nil
 }
 
-// DeleteTimer deletes the timer from MongoDB by ChatID and ID
-func (mstore *MongoStore) DeleteTimer(chatID int64, ID string) error {
-	// log.Printf("[deleteTimer]: ChatID: %d, ID: %s\n", ChatID, ID)
-	timer, err := mstore.GetTimerByChatAndID(chatID, ID)
+// SaveTimer saves the timer into MongoDB. ID is generated unless already set
+func (mstore *MongoStore) SaveTimer(ctx context.Context, timer *timer.Timer) error {
+	sess, err := mstore.session(ctx)
 	if err != nil {
 		return err
 	}
-	if timer == nil {
-		return errors.New("No such timer")
+	defer sess.Close()
+
+	if timer.ID == "" {
+		timer.ID = fmt.Sprintf("%s", uuid.NewV4())
+	}
+	if timer.Due.IsZero() {
+		timer.Due = timer.NextDue()
+	}
+	TimersCollection := sess.DB("TimerBot").C("timers")
+	err = TimersCollection.Insert(timer)
+	return wrapErr("save timer", err)
+}
+
+// DeleteTimer deletes the timer from MongoDB by ChatID and ID
+func (mstore *MongoStore) DeleteTimer(ctx context.Context, chatID int64, ID string) error {
+	sess, err := mstore.session(ctx)
+	if err != nil {
+		return err
 	}
+	defer sess.Close()
 
-	TimersCollection := mstore.msess.DB("TimerBot").C("timers")
-	err = TimersCollection.Remove(bson.M{"id": timer.ID})
+	TimersCollection := sess.DB("TimerBot").C("timers")
+	err = TimersCollection.Remove(bson.M{"chatid": chatID, "id": ID})
+	return wrapErr("delete timer", err)
+}
+
+// UpdateTimer replaces fire time, text, warnings and progress of the timer in MongoDB.
+// Timer is rescheduled to its next due time unless its due changed since it was read
+func (mstore *MongoStore) UpdateTimer(ctx context.Context, timer *timer.Timer) error {
+	sess, err := mstore.session(ctx)
 	if err != nil {
 		return err
 	}
+	defer sess.Close()
+
+	TimersCollection := sess.DB("TimerBot").C("timers")
+	err = TimersCollection.Update(
+		bson.M{"chatid": timer.ChatID, "id": timer.ID, "due": timer.Due},
+		bson.M{"$set": bson.M{
+			"at":       timer.At,
+			"body":     timer.Body,
+			"warnings": timer.Warnings,
+			"warned":   timer.Warned,
+			"fired":    timer.Fired,
+			"nagged":   timer.Nagged,
+			"due":      timer.NextDue(),
+		}},
+	)
+	if err == mgo.ErrNotFound {
+		return fmt.Errorf("mongodb: update timer: %w", storage.ErrConflict)
+	}
+	if err != nil {
+		return wrapErr("update timer", err)
+	}
+	timer.Due = timer.NextDue()
 	return nil
 }
 
 // GetTimerByChatAndID returns timer by ChatID and ID from MongoDB
-func (mstore *MongoStore) GetTimerByChatAndID(chatID int64, ID string) (timer *timer.Timer, err error) {
-	TimersCollection := mstore.msess.DB("TimerBot").C("timers")
+func (mstore *MongoStore) GetTimerByChatAndID(ctx context.Context, chatID int64, ID string) (*timer.Timer, error) {
+	sess, err := mstore.session(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+
+	TimersCollection := sess.DB("TimerBot").C("timers")
 	filters := bson.M{
 		"chatid": chatID,
 		"id":     ID,
 	}
-	err = TimersCollection.Find(filters).One(&timer)
+	var t timer.Timer
+	err = TimersCollection.Find(filters).One(&t)
+	if err != nil {
+		return nil, wrapErr("get timer", err)
+	}
+	return &t, nil
+}
+
+// GetNearestTimer returns first timer in MongoDB by due time, skipping
+// timers waiting for server state
+func (mstore *MongoStore) GetNearestTimer(ctx context.Context) (*timer.Timer, error) {
+	sess, err := mstore.session(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+
+	TimersCollection := sess.DB("TimerBot").C("timers")
+	var t timer.Timer
+	err = TimersCollection.Find(bson.M{"trigger": bson.M{"$in": []interface{}{nil, ""}}}).Sort("due").One(&t)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, wrapErr("get nearest timer", err)
+	}
+	return &t, nil
+}
+
+// ClaimTimer moves due of the timer to until unless someone did it before
+func (mstore *MongoStore) ClaimTimer(ctx context.Context, timer *timer.Timer, until time.Time) error {
+	sess, err := mstore.session(ctx)
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
 
+	TimersCollection := sess.DB("TimerBot").C("timers")
+	err = TimersCollection.Update(
+		bson.M{"id": timer.ID, "due": timer.Due},
+		bson.M{"$set": bson.M{"due": until}, "$inc": bson.M{"attempts": 1}},
+	)
+	if err == mgo.ErrNotFound {
+		return fmt.Errorf("mongodb: claim timer: %w", storage.ErrConflict)
+	}
 	if err != nil {
-		log.Println(err.Error())
+		return wrapErr("claim timer", err)
 	}
-	return
+	timer.Due = until
+	timer.Attempts++
+	return nil
 }
 
-// GetNearestTimer returns first timer in MongoDB by fire time
-func (mstore *MongoStore) GetNearestTimer() (timer *timer.Timer, err error) {
-	TimersCollection := mstore.msess.DB("TimerBot").C("timers")
-	err = TimersCollection.Find(bson.M{}).Sort("at").One(&timer)
-	if err != nil && err.Error() != "not found" {
-		return
+// RescheduleTimer moves due of claimed timer and saves its progress
+func (mstore *MongoStore) RescheduleTimer(ctx context.Context, timer *timer.Timer, due time.Time) error {
+	sess, err := mstore.session(ctx)
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	TimersCollection := sess.DB("TimerBot").C("timers")
+	err = TimersCollection.Update(
+		bson.M{"id": timer.ID, "due": timer.Due},
+		bson.M{"$set": bson.M{
+			"due":      due,
+			"warned":   timer.Warned,
+			"fired":    timer.Fired,
+			"nagged":   timer.Nagged,
+			"attempts": 0,
+		}},
+	)
+	if err == mgo.ErrNotFound {
+		return fmt.Errorf("mongodb: reschedule timer: %w", storage.ErrConflict)
+	}
+	if err != nil {
+		return wrapErr("reschedule timer", err)
 	}
-	return timer, nil
+	timer.Due = due
+	timer.Attempts = 0
+	return nil
 }
 
 // ListChatTimers returns array of timers by ChatID ordered by time
-func (mstore *MongoStore) ListChatTimers(chatID int64) (timers []timer.Timer, err error) {
-	TimersCollection := mstore.msess.DB("TimerBot").C("timers")
+func (mstore *MongoStore) ListChatTimers(ctx context.Context, chatID int64) (timers []timer.Timer, err error) {
+	sess, err := mstore.session(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+
+	TimersCollection := sess.DB("TimerBot").C("timers")
 	err = TimersCollection.Find(bson.M{"chatid": chatID}).Sort("at").All(&timers)
-	log.Printf("timer: %v", timers)
+	return timers, wrapErr("list chat timers", err)
+}
 
+// ListTriggeredTimers returns timers waiting for server state
+func (mstore *MongoStore) ListTriggeredTimers(ctx context.Context) (timers []timer.Timer, err error) {
+	sess, err := mstore.session(ctx)
 	if err != nil {
-		log.Println(err.Error())
+		return nil, err
 	}
-	return
+	defer sess.Close()
+
+	TimersCollection := sess.DB("TimerBot").C("timers")
+	err = TimersCollection.Find(bson.M{"trigger": bson.M{"$nin": []interface{}{nil, ""}}}).All(&timers)
+	return timers, wrapErr("list triggered timers", err)
 }
 
-// AppendToSSList adds chatID to list of subscribtions of server status changes
-func (mstore *MongoStore) AppendToSSList(chatID int64) error {
-	SubsCollection := mstore.msess.DB("TimerBot").C("subs")
-	changeInfo, err := SubsCollection.UpsertId(chatID, bson.M{"chat": chatID})
-	log.Printf("%+v", changeInfo)
-	if changeInfo.Updated > 0 {
-		return errors.New("Already subscribed")
+// ListAssignedTimers returns timers assigned to user ordered by time
+func (mstore *MongoStore) ListAssignedTimers(ctx context.Context, userID int, username string) (timers []timer.Timer, err error) {
+	sess, err := mstore.session(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+
+	filters := []bson.M{{"assignees.userid": userID}}
+	if username != "" {
+		filters = append(filters, bson.M{"assignees.username": username})
 	}
+	TimersCollection := sess.DB("TimerBot").C("timers")
+	err = TimersCollection.Find(bson.M{"$or": filters}).Sort("at").All(&timers)
+	return timers, wrapErr("list assigned timers", err)
+}
+
+// WalkTimers iterates over all timers in MongoDB ordered by time
+func (mstore *MongoStore) WalkTimers(ctx context.Context, fn func(*timer.Timer) error) error {
+	sess, err := mstore.session(ctx)
 	if err != nil {
-		log.Println(err.Error())
+		return err
 	}
-	return err
+	defer sess.Close()
+
+	TimersCollection := sess.DB("TimerBot").C("timers")
+	iter := TimersCollection.Find(nil).Sort("at").Iter()
+	for {
+		var t timer.Timer
+		if !iter.Next(&t) {
+			break
+		}
+		if err = ctx.Err(); err != nil {
+			iter.Close()
+			return err
+		}
+		if err = fn(&t); err != nil {
+			iter.Close()
+			return err
+		}
+	}
+	return wrapErr("walk timers", iter.Close())
 }
 
-// DeleteFromSSList removes chatID from list of subscriptions of server status changes
-func (mstore *MongoStore) DeleteFromSSList(chatID int64) {
-	SubsCollection := mstore.msess.DB("TimerBot").C("subs")
-	err := SubsCollection.RemoveId(chatID)
+// appendToList adds chatID to subscription list kept in collection
+func (mstore *MongoStore) appendToList(ctx context.Context, collection string, op string, chatID int64) error {
+	sess, err := mstore.session(ctx)
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	SubsCollection := sess.DB("TimerBot").C(collection)
+	changeInfo, err := SubsCollection.UpsertId(chatID, bson.M{"chat": chatID})
 	if err != nil {
-		log.Println(err.Error())
+		return wrapErr(op, err)
 	}
+	if changeInfo.Updated > 0 || changeInfo.Matched > 0 {
+		return fmt.Errorf("mongodb: %s: %w", op, storage.ErrAlreadySubscribed)
+	}
+	return nil
 }
 
-// GetSSChats return array of chats subscribed to server status changes
-func (mstore *MongoStore) GetSSChats() (chats []int64) {
+// deleteFromList removes chatID from subscription list kept in collection
+func (mstore *MongoStore) deleteFromList(ctx context.Context, collection string, op string, chatID int64) error {
+	sess, err := mstore.session(ctx)
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	SubsCollection := sess.DB("TimerBot").C(collection)
+	err = SubsCollection.RemoveId(chatID)
+	return wrapErr(op, err)
+}
+
+// listChats returns chats of subscription list kept in collection
+func (mstore *MongoStore) listChats(ctx context.Context, collection string, op string) (chats []int64, err error) {
+	sess, err := mstore.session(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+
 	type Ch struct {
 		Chat int64
 	}
 	var ch []Ch
-	SubsCollection := mstore.msess.DB("TimerBot").C("subs")
-	err := SubsCollection.Find(nil).All(&ch)
+	SubsCollection := sess.DB("TimerBot").C(collection)
+	err = SubsCollection.Find(nil).All(&ch)
 	if err != nil {
-		log.Printf("err: %s", err)
+		return nil, wrapErr(op, err)
 	}
-	log.Printf("ch: %+v", &ch)
 	for _, val := range ch {
-		log.Printf("val: %+v", val)
 		chats = append(chats, val.Chat)
 	}
-	log.Printf("chats: %+v", chats)
-	return
+	return chats, nil
+}
+
+// AppendToSSList adds chatID to list of subscribtions of server status changes
+func (mstore *MongoStore) AppendToSSList(ctx context.Context, chatID int64) error {
+	return mstore.appendToList(ctx, "subs", "append to ss list", chatID)
+}
+
+// DeleteFromSSList removes chatID from list of subscriptions of server status changes
+func (mstore *MongoStore) DeleteFromSSList(ctx context.Context, chatID int64) error {
+	return mstore.deleteFromList(ctx, "subs", "delete from ss list", chatID)
+}
+
+// GetSSChats return array of chats subscribed to server status changes
+func (mstore *MongoStore) GetSSChats(ctx context.Context) ([]int64, error) {
+	return mstore.listChats(ctx, "subs", "get ss chats")
+}
+
+// AppendToNewsList adds chatID to list of subscriptions of game news
+func (mstore *MongoStore) AppendToNewsList(ctx context.Context, chatID int64) error {
+	return mstore.appendToList(ctx, "newssubs", "append to news list", chatID)
+}
+
+// DeleteFromNewsList removes chatID from list of subscriptions of game news
+func (mstore *MongoStore) DeleteFromNewsList(ctx context.Context, chatID int64) error {
+	return mstore.deleteFromList(ctx, "newssubs", "delete from news list", chatID)
+}
+
+// GetNewsChats returns chats subscribed to game news
+func (mstore *MongoStore) GetNewsChats(ctx context.Context) ([]int64, error) {
+	return mstore.listChats(ctx, "newssubs", "get news chats")
+}
+
+// AppendToClientList adds chatID to list of subscriptions of client updates
+func (mstore *MongoStore) AppendToClientList(ctx context.Context, chatID int64) error {
+	return mstore.appendToList(ctx, "clientsubs", "append to client list", chatID)
+}
+
+// DeleteFromClientList removes chatID from list of subscriptions of client updates
+func (mstore *MongoStore) DeleteFromClientList(ctx context.Context, chatID int64) error {
+	return mstore.deleteFromList(ctx, "clientsubs", "delete from client list", chatID)
+}
+
+// GetClientChats returns chats subscribed to client updates
+func (mstore *MongoStore) GetClientChats(ctx context.Context) ([]int64, error) {
+	return mstore.listChats(ctx, "clientsubs", "get client chats")
+}
+
+// watermark is a document of watermarks collection
+type watermark struct {
+	Name  string `bson:"_id"`
+	Value string `bson:"value"`
+}
+
+// GetWatermark returns value saved under name
+func (mstore *MongoStore) GetWatermark(ctx context.Context, name string) (string, error) {
+	sess, err := mstore.session(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer sess.Close()
+
+	var w watermark
+	WatermarksCollection := sess.DB("TimerBot").C("watermarks")
+	err = WatermarksCollection.FindId(name).One(&w)
+	if err != nil {
+		return "", wrapErr("get watermark", err)
+	}
+	return w.Value, nil
+}
+
+// SaveWatermark upserts value under name
+func (mstore *MongoStore) SaveWatermark(ctx context.Context, name string, value string) error {
+	sess, err := mstore.session(ctx)
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	WatermarksCollection := sess.DB("TimerBot").C("watermarks")
+	_, err = WatermarksCollection.UpsertId(name, watermark{Name: name, Value: value})
+	return wrapErr("save watermark", err)
+}
+
+// ListWatermarks returns all watermarks by name
+func (mstore *MongoStore) ListWatermarks(ctx context.Context) (map[string]string, error) {
+	sess, err := mstore.session(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+
+	var list []watermark
+	WatermarksCollection := sess.DB("TimerBot").C("watermarks")
+	err = WatermarksCollection.Find(nil).All(&list)
+	if err != nil {
+		return nil, wrapErr("list watermarks", err)
+	}
+	marks := make(map[string]string, len(list))
+	for _, w := range list {
+		marks[w.Name] = w.Value
+	}
+	return marks, nil
+}
+
+// GetChatSettings returns settings of chat from settings collection
+func (mstore *MongoStore) GetChatSettings(ctx context.Context, chatID int64) (*settings.Chat, error) {
+	sess, err := mstore.session(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+
+	var c settings.Chat
+	SettingsCollection := sess.DB("TimerBot").C("settings")
+	err = SettingsCollection.FindId(chatID).One(&c)
+	if err != nil {
+		return nil, wrapErr("get chat settings", err)
+	}
+	return &c, nil
+}
+
+// SaveChatSettings upserts settings of chat keyed by chat ID
+func (mstore *MongoStore) SaveChatSettings(ctx context.Context, c *settings.Chat) error {
+	sess, err := mstore.session(ctx)
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	SettingsCollection := sess.DB("TimerBot").C("settings")
+	_, err = SettingsCollection.UpsertId(c.ChatID, c)
+	return wrapErr("save chat settings", err)
+}
+
+// ListChatSettings returns settings of all chats
+func (mstore *MongoStore) ListChatSettings(ctx context.Context) (chats []settings.Chat, err error) {
+	sess, err := mstore.session(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+
+	SettingsCollection := sess.DB("TimerBot").C("settings")
+	err = SettingsCollection.Find(nil).All(&chats)
+	return chats, wrapErr("list chat settings", err)
+}
+
+// chatToken is a document of tokens collection
+type chatToken struct {
+	ID   string `bson:"_id"`
+	Kind string `bson:"kind"`
+	Chat int64  `bson:"chat"`
+	Hash string `bson:"hash"`
+}
+
+func chatTokenID(kind string, chatID int64) string {
+	return fmt.Sprintf("%s:%d", kind, chatID)
+}
+
+// CreateChatToken stores hash of chat secret of given kind
+func (mstore *MongoStore) CreateChatToken(ctx context.Context, kind string, chatID int64, hash string) error {
+	sess, err := mstore.session(ctx)
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	TokensCollection := sess.DB("TimerBot").C("tokens")
+	err = TokensCollection.Insert(chatToken{ID: chatTokenID(kind, chatID), Kind: kind, Chat: chatID, Hash: hash})
+	return wrapErr("create chat token", err)
+}
+
+// ReplaceChatToken swaps hash of existing chat secret
+func (mstore *MongoStore) ReplaceChatToken(ctx context.Context, kind string, chatID int64, hash string) error {
+	sess, err := mstore.session(ctx)
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	TokensCollection := sess.DB("TimerBot").C("tokens")
+	err = TokensCollection.UpdateId(chatTokenID(kind, chatID), bson.M{"$set": bson.M{"hash": hash}})
+	return wrapErr("replace chat token", err)
+}
+
+// DeleteChatToken removes chat secret of given kind
+func (mstore *MongoStore) DeleteChatToken(ctx context.Context, kind string, chatID int64) error {
+	sess, err := mstore.session(ctx)
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	TokensCollection := sess.DB("TimerBot").C("tokens")
+	err = TokensCollection.RemoveId(chatTokenID(kind, chatID))
+	return wrapErr("delete chat token", err)
+}
+
+// GetChatByToken returns chat owning secret with given hash
+func (mstore *MongoStore) GetChatByToken(ctx context.Context, kind string, hash string) (int64, error) {
+	sess, err := mstore.session(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer sess.Close()
+
+	var token chatToken
+	TokensCollection := sess.DB("TimerBot").C("tokens")
+	err = TokensCollection.Find(bson.M{"kind": kind, "hash": hash}).One(&token)
+	if err != nil {
+		return 0, wrapErr("get chat by token", err)
+	}
+	return token.Chat, nil
+}
+
+// ListChatTokens returns secrets of all kinds and chats
+func (mstore *MongoStore) ListChatTokens(ctx context.Context) ([]storage.ChatToken, error) {
+	sess, err := mstore.session(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+
+	var list []chatToken
+	TokensCollection := sess.DB("TimerBot").C("tokens")
+	err = TokensCollection.Find(nil).All(&list)
+	if err != nil {
+		return nil, wrapErr("list chat tokens", err)
+	}
+	tokens := make([]storage.ChatToken, 0, len(list))
+	for _, t := range list {
+		tokens = append(tokens, storage.ChatToken{Kind: t.Kind, ChatID: t.Chat, Hash: t.Hash})
+	}
+	return tokens, nil
+}
+
+// SavePlot saves crop plot into MongoDB
+func (mstore *MongoStore) SavePlot(ctx context.Context, p *plot.Plot) error {
+	sess, err := mstore.session(ctx)
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	PlotsCollection := sess.DB("TimerBot").C("plots")
+	err = PlotsCollection.Insert(p)
+	return wrapErr("save plot", err)
+}
+
+// ListChatPlots returns plots of chat ordered by planting time
+func (mstore *MongoStore) ListChatPlots(ctx context.Context, chatID int64) (plots []plot.Plot, err error) {
+	sess, err := mstore.session(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+
+	PlotsCollection := sess.DB("TimerBot").C("plots")
+	err = PlotsCollection.Find(bson.M{"chatid": chatID}).Sort("planted").All(&plots)
+	return plots, wrapErr("list chat plots", err)
+}
+
+// DeletePlot deletes plot from MongoDB by ChatID and name
+func (mstore *MongoStore) DeletePlot(ctx context.Context, chatID int64, name string) error {
+	sess, err := mstore.session(ctx)
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	PlotsCollection := sess.DB("TimerBot").C("plots")
+	err = PlotsCollection.Remove(bson.M{"chatid": chatID, "name": name})
+	return wrapErr("delete plot", err)
+}
+
+// ListPlots returns plots of all chats
+func (mstore *MongoStore) ListPlots(ctx context.Context) (plots []plot.Plot, err error) {
+	sess, err := mstore.session(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+
+	PlotsCollection := sess.DB("TimerBot").C("plots")
+	err = PlotsCollection.Find(nil).All(&plots)
+	return plots, wrapErr("list plots", err)
+}
+
+// AcquireLease takes or extends named lease kept in leases collection
+func (mstore *MongoStore) AcquireLease(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error) {
+	sess, err := mstore.session(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer sess.Close()
+
+	now := time.Now()
+	LeasesCollection := sess.DB("TimerBot").C("leases")
+	_, err = LeasesCollection.Upsert(
+		bson.M{"_id": name, "$or": []bson.M{{"holder": holder}, {"until": bson.M{"$lt": now}}}},
+		bson.M{"$set": bson.M{"holder": holder, "until": now.Add(ttl)}},
+	)
+	// lease held by someone else doesn't match, so upsert tries to insert it again
+	if mgo.IsDup(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, wrapErr("acquire lease", err)
+	}
+	return true, nil
+}
+
+// ReleaseLease removes named lease if it is held by holder
+func (mstore *MongoStore) ReleaseLease(ctx context.Context, name string, holder string) error {
+	sess, err := mstore.session(ctx)
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	LeasesCollection := sess.DB("TimerBot").C("leases")
+	err = LeasesCollection.Remove(bson.M{"_id": name, "holder": holder})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return wrapErr("release lease", err)
 }
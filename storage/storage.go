@@ -1,16 +1,109 @@
 package storage
 
-import "github.com/mementor/hafenbot/timer"
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mementor/hafenbot/plot"
+	"github.com/mementor/hafenbot/settings"
+	"github.com/mementor/hafenbot/timer"
+)
+
+// Errors returned by storage drivers. Drivers wrap them, so check with errors.Is
+var (
+	// ErrNotFound means requested timer or subscription does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadySubscribed means chat is already in the SS list
+	ErrAlreadySubscribed = errors.New("already subscribed")
+	// ErrConflict means object with the same ID is already stored
+	ErrConflict = errors.New("conflict")
+)
+
+// ChatToken is hash of chat secret of given kind, see CreateChatToken
+type ChatToken struct {
+	Kind   string
+	ChatID int64
+	Hash   string
+}
 
 // Storage interface defines methods of storage drivers
 type Storage interface {
-	SaveTimer(*timer.Timer) error
-	DeleteTimer(int64, string) error
-	GetNearestTimer() (*timer.Timer, error)
-	ListChatTimers(int64) ([]timer.Timer, error)
-	GetTimerByChatAndID(int64, string) (*timer.Timer, error)
-	AppendToSSList(chatID int64) error
-	DeleteFromSSList(int64)
-	GetSSChats() []int64
-	// GetMongoStore(string) (*MongoStore, error)
+	// Ping checks that storage is reachable
+	Ping(context.Context) error
+	// Close releases connections, storage is unusable afterwards
+	Close() error
+	SaveTimer(context.Context, *timer.Timer) error
+	DeleteTimer(context.Context, int64, string) error
+	// UpdateTimer replaces fire time, text, warnings and firing progress of
+	// timer found by ChatID and ID and reschedules it to NextDue. Returns
+	// ErrConflict if Due of the timer changed since it was read, e.g. it was
+	// claimed by a scheduler
+	UpdateTimer(context.Context, *timer.Timer) error
+	// GetNearestTimer returns timer with the earliest Due, timers with
+	// Trigger are skipped. Returns nil timer and nil error when there are none
+	GetNearestTimer(context.Context) (*timer.Timer, error)
+	// ClaimTimer atomically moves Due of timer from t.Due to until and counts
+	// the attempt, so other schedulers skip it till then. Returns ErrConflict
+	// if timer was claimed, changed or deleted since it was read
+	ClaimTimer(ctx context.Context, t *timer.Timer, until time.Time) error
+	// RescheduleTimer stores progress of claimed timer: moves its Due to due,
+	// saves Warned, Fired and Nagged and resets Attempts.
+	// Returns ErrConflict if claim was lost
+	RescheduleTimer(ctx context.Context, t *timer.Timer, due time.Time) error
+	ListChatTimers(context.Context, int64) ([]timer.Timer, error)
+	// ListTriggeredTimers returns timers with Trigger of all chats
+	ListTriggeredTimers(context.Context) ([]timer.Timer, error)
+	// ListAssignedTimers returns timers of all chats assigned to user with
+	// given ID or lowercase username, ordered by fire time
+	ListAssignedTimers(ctx context.Context, userID int, username string) ([]timer.Timer, error)
+	GetTimerByChatAndID(context.Context, int64, string) (*timer.Timer, error)
+	// WalkTimers calls fn for every stored timer until fn returns an error
+	WalkTimers(context.Context, func(*timer.Timer) error) error
+	AppendToSSList(ctx context.Context, chatID int64) error
+	DeleteFromSSList(ctx context.Context, chatID int64) error
+	GetSSChats(context.Context) ([]int64, error)
+	AppendToNewsList(ctx context.Context, chatID int64) error
+	DeleteFromNewsList(ctx context.Context, chatID int64) error
+	GetNewsChats(context.Context) ([]int64, error)
+	AppendToClientList(ctx context.Context, chatID int64) error
+	DeleteFromClientList(ctx context.Context, chatID int64) error
+	GetClientChats(context.Context) ([]int64, error)
+	// GetWatermark returns value watcher saved under name, e.g. last seen
+	// news item. Returns ErrNotFound if there is none
+	GetWatermark(ctx context.Context, name string) (string, error)
+	// SaveWatermark creates or replaces value under name
+	SaveWatermark(ctx context.Context, name string, value string) error
+	// ListWatermarks returns all watermarks by name
+	ListWatermarks(context.Context) (map[string]string, error)
+	// GetChatSettings returns server notification settings of chat,
+	// ErrNotFound if chat has none
+	GetChatSettings(ctx context.Context, chatID int64) (*settings.Chat, error)
+	// SaveChatSettings creates or replaces settings of chat
+	SaveChatSettings(ctx context.Context, c *settings.Chat) error
+	// ListChatSettings returns settings of all chats
+	ListChatSettings(context.Context) ([]settings.Chat, error)
+	// CreateChatToken stores hash of chat secret of given kind.
+	// Returns ErrConflict if chat already has one
+	CreateChatToken(ctx context.Context, kind string, chatID int64, hash string) error
+	// ReplaceChatToken swaps hash of existing chat secret, ErrNotFound if none
+	ReplaceChatToken(ctx context.Context, kind string, chatID int64, hash string) error
+	DeleteChatToken(ctx context.Context, kind string, chatID int64) error
+	// GetChatByToken returns chat owning secret with given hash
+	GetChatByToken(ctx context.Context, kind string, hash string) (int64, error)
+	// ListChatTokens returns secrets of all kinds and chats
+	ListChatTokens(context.Context) ([]ChatToken, error)
+	// SavePlot stores crop plot. Returns ErrConflict if chat already has
+	// plot with the same name
+	SavePlot(ctx context.Context, p *plot.Plot) error
+	// ListChatPlots returns plots of chat ordered by planting time
+	ListChatPlots(ctx context.Context, chatID int64) ([]plot.Plot, error)
+	DeletePlot(ctx context.Context, chatID int64, name string) error
+	// ListPlots returns plots of all chats
+	ListPlots(context.Context) ([]plot.Plot, error)
+	// AcquireLease takes named lease for holder or extends it, so that
+	// it expires after ttl. Returns false if it is held by someone else
+	AcquireLease(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error)
+	// ReleaseLease gives named lease up if it is held by holder
+	ReleaseLease(ctx context.Context, name string, holder string) error
 }
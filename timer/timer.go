@@ -7,4 +7,89 @@ type Timer struct {
 	Body   string
 	ChatID int64
 	ID     string
+	// Due is when scheduler has to look at the timer next. It is NextDue for
+	// a timer waiting to fire and lease expiration for a timer being fired.
+	// Storage drivers set it to NextDue when timer is saved without it
+	Due time.Time
+	// Attempts counts claims of the timer for firing
+	Attempts int
+	// Warnings are lead times of notices sent before At, longest first
+	Warnings []time.Duration
+	// Warned is number of Warnings already sent or skipped
+	Warned int
+	// CreatorID and CreatorName identify Telegram user who created the timer
+	CreatorID   int
+	CreatorName string
+	// NagCount is how many times fired timer is re-sent every NagEvery
+	// until it is marked done. NagMention makes re-sent timers mention creator
+	NagEvery   time.Duration
+	NagCount   int
+	NagMention bool
+	// Fired is set once timer is sent while it is kept for nagging
+	Fired bool
+	// Nagged is number of re-sent reminders
+	Nagged int
+	// Assignees are users mentioned when timer fires
+	Assignees []Assignee
+	// DeliverTo is chat to send the timer to instead of ChatID, e.g.
+	// private chat of the creator. Zero means ChatID
+	DeliverTo int64
+	// Trigger is server state condition the timer waits for instead of At,
+	// e.g. "up" or "online>500". At is creation time of such timers and
+	// they are not scheduled. TriggerEvery keeps timer after it fires,
+	// so that it fires every time the condition becomes met
+	Trigger      string
+	TriggerEvery bool
+}
+
+// Target returns chat the timer is sent to
+func (t *Timer) Target() int64 {
+	if t.DeliverTo != 0 {
+		return t.DeliverTo
+	}
+	return t.ChatID
+}
+
+// Assignee is a user responsible for a timer. Users mentioned by @username
+// are known only by Username, users mentioned by name only by UserID
+type Assignee struct {
+	UserID int
+	// Username is lowercase and without @
+	Username string
+	Name     string
+}
+
+// NextDue returns time of the next pending warning, of the timer itself or
+// of the next nag once the timer is fired
+func (t *Timer) NextDue() time.Time {
+	if t.Fired {
+		return t.At.Add(t.NagEvery * time.Duration(t.Nagged+1))
+	}
+	if t.Warned < len(t.Warnings) {
+		return t.At.Add(-t.Warnings[t.Warned])
+	}
+	return t.At
+}
+
+// PendingWarnings returns lead times of warnings not sent yet
+func (t *Timer) PendingWarnings() []time.Duration {
+	if t.Warned >= len(t.Warnings) {
+		return nil
+	}
+	return t.Warnings[t.Warned:]
+}
+
+// Rearm makes timer with changed fire time wait for it again
+func (t *Timer) Rearm(now time.Time) {
+	t.Fired = false
+	t.Nagged = 0
+	t.ResetWarnings(now)
+}
+
+// ResetWarnings skips warnings which are already late at now
+func (t *Timer) ResetWarnings(now time.Time) {
+	t.Warned = 0
+	for t.Warned < len(t.Warnings) && !t.At.Add(-t.Warnings[t.Warned]).After(now) {
+		t.Warned++
+	}
 }
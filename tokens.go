@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/mementor/hafenbot/storage"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// Kinds of chat secrets kept in storage
+const (
+	tokenKindICal   = "ical"
+	tokenKindAPIKey = "apikey"
+)
+
+// newToken returns random secret. Only its hash goes to storage
+func newToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// manageChatToken applies on|off|rotate to chat secret of given kind.
+// Returns new secret for on and rotate, storage errors are passed as is
+func manageChatToken(ctx context.Context, dbstore storage.Storage, kind string, chatID int64, mode string) (string, error) {
+	switch mode {
+	case "on", "rotate":
+		token, err := newToken()
+		if err != nil {
+			return "", err
+		}
+		if mode == "on" {
+			err = dbstore.CreateChatToken(ctx, kind, chatID, hashToken(token))
+		} else {
+			err = dbstore.ReplaceChatToken(ctx, kind, chatID, hashToken(token))
+		}
+		if err != nil {
+			return "", err
+		}
+		return token, nil
+	case "off":
+		return "", dbstore.DeleteChatToken(ctx, kind, chatID)
+	}
+	return "", fmt.Errorf("unknown mode '%s'", mode)
+}
+
+// requireAdmin tells whether sender of msg may manage chat secrets. In
+// groups only creator and administrators may, since rotating a secret
+// breaks integrations of other members. Replies to everyone else
+func requireAdmin(ctx context.Context, bot *tgbotapi.BotAPI, msg *tgbotapi.Message) bool {
+	if msg.Chat.IsPrivate() {
+		return true
+	}
+	if msg.From != nil {
+		member, err := bot.GetChatMember(tgbotapi.ChatConfigWithUser{ChatID: msg.Chat.ID, UserID: msg.From.ID})
+		if err != nil {
+			logger(ctx).Warn("can't get chat member", "err", err)
+			bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "error: can't check your rights, try again later"))
+			return false
+		}
+		if member.IsCreator() || member.IsAdministrator() {
+			return true
+		}
+	}
+	bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "error: only chat admins can do this"))
+	return false
+}
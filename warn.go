@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mementor/hafenbot/storage"
+	"github.com/mementor/hafenbot/timer"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// maxWarnings limits number of pre-reminders of a timer
+const maxWarnings = 5
+
+// parseWarnings parses comma separated lead times like "30m,5m" and returns
+// them longest first
+func parseWarnings(str string) ([]time.Duration, error) {
+	if str == "" {
+		return nil, errors.New("no lead times after --warn, e.g. --warn 30m,5m")
+	}
+	seen := make(map[time.Duration]bool)
+	var warnings []time.Duration
+	for _, part := range strings.Split(str, ",") {
+		lead, err := parseDuration(part)
+		if err != nil {
+			return nil, fmt.Errorf("bad lead time '%s'", part)
+		}
+		lead = lead.Round(time.Second)
+		if lead <= 0 || seen[lead] {
+			continue
+		}
+		seen[lead] = true
+		warnings = append(warnings, lead)
+	}
+	if len(warnings) > maxWarnings {
+		return nil, fmt.Errorf("too many lead times, %d max", maxWarnings)
+	}
+	sort.Slice(warnings, func(i, j int) bool { return warnings[i] > warnings[j] })
+	return warnings, nil
+}
+
+// formatDuration prints duration the way parseDuration reads it, e.g. 1d2h30m
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	if d < time.Minute {
+		return fmt.Sprintf("%ds", int(d/time.Second))
+	}
+	d = d.Round(time.Minute)
+	var b strings.Builder
+	for _, unit := range []struct {
+		size time.Duration
+		name string
+	}{{24 * time.Hour, "d"}, {time.Hour, "h"}, {time.Minute, "m"}} {
+		if n := d / unit.size; n > 0 {
+			fmt.Fprintf(&b, "%d%s", n, unit.name)
+			d -= n * unit.size
+		}
+	}
+	return b.String()
+}
+
+// formatWarnings lists lead times like "30m, 5m"
+func formatWarnings(warnings []time.Duration) string {
+	leads := make([]string, 0, len(warnings))
+	for _, w := range warnings {
+		leads = append(leads, formatDuration(w))
+	}
+	return strings.Join(leads, ", ")
+}
+
+// warnTimer sends pending warning of claimed timer and schedules the next
+// one. When the bot is late only the latest of overdue warnings is sent
+func warnTimer(ctx context.Context, store storage.Storage, bot *tgbotapi.BotAPI, t *timer.Timer, lg *slog.Logger) {
+	now := time.Now()
+	for t.Warned+1 < len(t.Warnings) && !t.At.Add(-t.Warnings[t.Warned+1]).After(now) {
+		t.Warned++
+	}
+	reply := fmt.Sprintf("⏳ in %s: %s", formatDuration(t.At.Sub(now)), t.Body)
+	if err := sendToTarget(bot, t, tgbotapi.NewMessage(t.ChatID, reply), lg); err != nil {
+		lg.Warn("can't send timer warning, will retry", "attempt", t.Attempts, "retry_at", t.Due, "err", err)
+		return
+	}
+	lg.Info("timer warning sent", "lead", t.Warnings[t.Warned])
+	t.Warned++
+	if err := store.RescheduleTimer(ctx, t, t.NextDue()); err != nil {
+		lg.Error("can't save warning progress, it may be sent again", "err", err)
+	}
+}
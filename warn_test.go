@@ -0,0 +1,65 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mementor/hafenbot/timer"
+)
+
+func TestParseWarnings(t *testing.T) {
+	tests := []struct {
+		str     string
+		want    []time.Duration
+		wantErr bool
+	}{
+		{str: "5m", want: []time.Duration{5 * time.Minute}},
+		{str: "5m,30m", want: []time.Duration{30 * time.Minute, 5 * time.Minute}},
+		{str: "1d,1h30m,90s", want: []time.Duration{24 * time.Hour, 90 * time.Minute, 90 * time.Second}},
+		{str: "0.5h,1w", want: []time.Duration{7 * 24 * time.Hour, 30 * time.Minute}},
+		// duplicates, also written differently, are kept once
+		{str: "30m,5m,30m,0.5h", want: []time.Duration{30 * time.Minute, 5 * time.Minute}},
+		{str: "0s,5m", wantErr: true},
+		{str: "1m,2m,3m,4m,5m,5m", want: []time.Duration{5 * time.Minute, 4 * time.Minute, 3 * time.Minute, 2 * time.Minute, time.Minute}},
+		{str: "1m,2m,3m,4m,5m,6m", wantErr: true},
+		{str: "", wantErr: true},
+		{str: "soon", wantErr: true},
+		{str: "5m,,10m", wantErr: true},
+		{str: "5x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.str, func(t *testing.T) {
+			got, err := parseWarnings(tt.str)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWarningsLaterThanTimer(t *testing.T) {
+	now := time.Now()
+	warnings, err := parseWarnings("1d,1h,5m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm := &timer.Timer{At: now.Add(2 * time.Hour), Warnings: warnings}
+	tm.ResetWarnings(now)
+	// a day before a timer in 2 hours has already passed
+	if want := []time.Duration{time.Hour, 5 * time.Minute}; !reflect.DeepEqual(tm.PendingWarnings(), want) {
+		t.Errorf("pending %v, want %v", tm.PendingWarnings(), want)
+	}
+	if due, want := tm.NextDue(), tm.At.Add(-time.Hour); !due.Equal(want) {
+		t.Errorf("due %v, want %v", due, want)
+	}
+
+	tm = &timer.Timer{At: now.Add(time.Minute), Warnings: warnings}
+	tm.ResetWarnings(now)
+	if len(tm.PendingWarnings()) != 0 || !tm.NextDue().Equal(tm.At) {
+		t.Errorf("all warnings are late, got pending %v, due %v", tm.PendingWarnings(), tm.NextDue())
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mementor/hafenbot/metrics"
+	"github.com/mementor/hafenbot/settings"
+	"github.com/mementor/hafenbot/storage"
+	"github.com/mementor/hafenbot/timer"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// stateWatchersTTL is how soon timers with Trigger and chat settings saved
+// by other instances are noticed
+const stateWatchersTTL = 5 * time.Minute
+
+var (
+	reTrigger = regexp.MustCompile(`^(up|down|online([<>])(\d+))$`)
+	// reServerUp matches portal status of running server
+	reServerUp = regexp.MustCompile(`(?i)\bup\b`)
+	reNumber   = regexp.MustCompile(`\d+`)
+)
+
+// ServerState is server status and players online seen on the portal
+type ServerState struct {
+	Status string
+	Online string
+}
+
+// serverUp tells whether status on the portal says server is running
+func serverUp(status string) bool {
+	return reServerUp.MatchString(status)
+}
+
+// playersOnline returns number of players in portal online text
+func playersOnline(online string) (int, bool) {
+	n, err := strconv.Atoi(reNumber.FindString(strings.ReplaceAll(online, ",", "")))
+	return n, err == nil
+}
+
+// stateWatchers caches timers with Trigger and chat settings, which are
+// checked on every server state change including every change of online
+// count. Changes made here invalidate it
+type stateWatchers struct {
+	mu       sync.Mutex
+	loaded   time.Time
+	timers   []timer.Timer
+	settings []settings.Chat
+}
+
+// watchers is the cache used by the main loop
+var watchers = &stateWatchers{}
+
+// invalidate makes the next get read storage
+func (w *stateWatchers) invalidate() {
+	w.mu.Lock()
+	w.loaded = time.Time{}
+	w.mu.Unlock()
+}
+
+// get returns timers with Trigger and chat settings, reading storage when
+// cache is invalidated or older than stateWatchersTTL
+func (w *stateWatchers) get(ctx context.Context, dbstore storage.Storage) ([]timer.Timer, []settings.Chat, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.loaded.IsZero() && time.Since(w.loaded) < stateWatchersTTL {
+		return w.timers, w.settings, nil
+	}
+	timers, err := dbstore.ListTriggeredTimers(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("list triggered timers: %w", err)
+	}
+	chats, err := dbstore.ListChatSettings(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("list chat settings: %w", err)
+	}
+	w.timers, w.settings, w.loaded = timers, chats, time.Now()
+	return w.timers, w.settings, nil
+}
+
+// forget drops timer fired for the last time from cache
+func (w *stateWatchers) forget(t *timer.Timer) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for i := range w.timers {
+		if w.timers[i].ID == t.ID {
+			w.timers = append(w.timers[:i:i], w.timers[i+1:]...)
+			return
+		}
+	}
+}
+
+// parseTrigger checks condition of /when: up, down, online>N or online<N
+func parseTrigger(str string) (string, error) {
+	str = strings.ToLower(str)
+	if !reTrigger.MatchString(str) {
+		return "", fmt.Errorf("bad condition '%s', use up, down, online>N or online<N", str)
+	}
+	return str, nil
+}
+
+// triggerMet tells whether server state meets the condition
+func triggerMet(trigger string, state ServerState) bool {
+	if state.Status == "" {
+		return false
+	}
+	m := reTrigger.FindStringSubmatch(trigger)
+	switch {
+	case m == nil:
+		return false
+	case m[1] == "up":
+		return serverUp(state.Status)
+	case m[1] == "down":
+		return !serverUp(state.Status)
+	}
+	online, ok := playersOnline(state.Online)
+	if !ok {
+		return false
+	}
+	threshold, _ := strconv.Atoi(m[3])
+	if m[2] == ">" {
+		return online > threshold
+	}
+	return online < threshold
+}
+
+// triggerFires tells whether condition becomes met on change from old to
+// cur state, so that timer fires once per crossing
+func triggerFires(trigger string, old, cur ServerState) bool {
+	return !triggerMet(trigger, old) && triggerMet(trigger, cur)
+}
+
+// describeTrigger returns human readable condition
+func describeTrigger(trigger string) string {
+	switch {
+	case trigger == "up":
+		return "when server is up"
+	case trigger == "down":
+		return "when server is down"
+	case strings.HasPrefix(trigger, "online>"):
+		return "when more than " + strings.TrimPrefix(trigger, "online>") + " players online"
+	}
+	return "when less than " + strings.TrimPrefix(trigger, "online<") + " players online"
+}
+
+// handleWhen creates timer fired on server state change:
+// /when [--every] <condition> <text>
+func handleWhen(ctx context.Context, bot *tgbotapi.BotAPI, dbstore storage.Storage, ss *ServerStatus, msg *tgbotapi.Message, args string) {
+	chatID := msg.Chat.ID
+	words, every := cutSwitch(strings.Fields(args), "--every")
+	if len(words) < 2 {
+		bot.Send(tgbotapi.NewMessage(chatID, "send me condition and text:\n /when up server is back\n /when online>500 crowded\n /when --every down server is down again"))
+		return
+	}
+	trigger, err := parseTrigger(words[0])
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("error: %s", err)))
+		return
+	}
+	body := strings.Join(words[1:], " ")
+	t := &timer.Timer{
+		At:           time.Now(),
+		Body:         body,
+		ChatID:       chatID,
+		CreatorID:    msg.From.ID,
+		CreatorName:  userName(msg.From),
+		Assignees:    parseAssignees(msg, body, bot.Self.UserName),
+		Trigger:      trigger,
+		TriggerEvery: every,
+	}
+	if err = dbstore.SaveTimer(ctx, t); err != nil {
+		logger(ctx).Error("can't save timer", "err", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "error: can't save timer, try again later"))
+		return
+	}
+	watchers.invalidate()
+	logger(ctx).Info("timer created", "timer_id", t.ID, "trigger", trigger, "every", every)
+	metrics.TimersCreated.WithLabelValues("when").Inc()
+
+	reply := fmt.Sprintf("⚡ fire %s", describeTrigger(trigger))
+	if every {
+		reply += ", every time"
+	}
+	if triggerMet(trigger, ss.State()) {
+		reply += "\ncondition is met already, timer fires next time it becomes met"
+	}
+	reply += "\n/timerdel " + t.ID + " to cancel"
+	bot.Send(tgbotapi.NewMessage(chatID, reply))
+}
+
+// fireTriggers sends timers whose condition was not met at old state and
+// is met at cur one. Timers come from cache, so they are checked in storage
+// before sending: timers without TriggerEvery are deleted first, so that
+// they are sent once, others are read to skip deleted ones
+func fireTriggers(ctx context.Context, dbstore storage.Storage, out *outbox, timers []timer.Timer, old, cur ServerState) {
+	for i := range timers {
+		t := &timers[i]
+		if !triggerFires(t.Trigger, old, cur) {
+			continue
+		}
+		lg := logger(ctx).With("chat_id", t.ChatID, "timer_id", t.ID)
+		var err error
+		if t.TriggerEvery {
+			_, err = dbstore.GetTimerByChatAndID(ctx, t.ChatID, t.ID)
+		} else {
+			err = dbstore.DeleteTimer(ctx, t.ChatID, t.ID)
+		}
+		if errors.Is(err, storage.ErrNotFound) {
+			watchers.invalidate()
+			continue
+		}
+		if err != nil {
+			lg.Error("can't check triggered timer", "err", err)
+			continue
+		}
+		if !t.TriggerEvery {
+			watchers.forget(t)
+		}
+		text := fmt.Sprintf("⚡ %s\n%s\n%s", describeTrigger(t.Trigger), t.Body, cur.Online)
+		msg := tgbotapi.NewMessage(t.ChatID, text)
+		addMentions(&msg, t, false)
+		out.Send(t.ChatID, msg)
+		lg.Info("timer triggered", "trigger", t.Trigger)
+		metrics.TimersFired.Inc()
+	}
+}
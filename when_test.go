@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestParseTrigger(t *testing.T) {
+	for _, str := range []string{"up", "DOWN", "online>500", "Online<10"} {
+		if _, err := parseTrigger(str); err != nil {
+			t.Errorf("parseTrigger(%q): %s", str, err)
+		}
+	}
+	for _, str := range []string{"", "online", "online>", "online=5", "online>-1", "upp"} {
+		if _, err := parseTrigger(str); err == nil {
+			t.Errorf("parseTrigger(%q) succeeded", str)
+		}
+	}
+}
+
+func TestTriggerFires(t *testing.T) {
+	up := func(online string) ServerState { return ServerState{Status: "The server is up", Online: online} }
+	down := ServerState{Status: "The server is down", Online: "unknown"}
+	tests := []struct {
+		name    string
+		trigger string
+		old     ServerState
+		cur     ServerState
+		want    bool
+	}{
+		{"comes up", "up", down, up("10 players"), true},
+		{"stays up", "up", up("10 players"), up("12 players"), false},
+		{"goes down", "down", up("10 players"), down, true},
+		{"first scrape", "up", ServerState{}, up("10 players"), true},
+		{"crosses above", "online>500", up("500 players"), up("501 players"), true},
+		{"stays above", "online>500", up("501 players"), up("1,200 players"), false},
+		{"falls back", "online>500", up("501 players"), up("499 players"), false},
+		{"crosses above with comma", "online>1000", up("999 players"), up("1,001 players"), true},
+		{"crosses below", "online<100", up("100 players"), up("99 players"), true},
+		{"unknown count", "online<100", up("100 players"), up("unknown"), false},
+		{"server goes down", "online<100", up("150 players"), down, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := triggerFires(tt.trigger, tt.old, tt.cur); got != tt.want {
+				t.Errorf("triggerFires(%q, %+v, %+v) = %v, want %v", tt.trigger, tt.old, tt.cur, got, tt.want)
+			}
+		})
+	}
+}